@@ -1,7 +1,6 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"os"
 
@@ -17,63 +16,57 @@ var (
 	chConfig ChymeConfig
 )
 
-// Unified configuration for Chyme
-type ChymeConfig struct {
-	WorkerWorkDir           string
-	WorkerDockerUser        string
-	WorkerDockerPull        string
-	WorkerDockerRemove      string
-	RedisAddress            string
-	RedisPassword           string
-	ResourceSetKey          string
-	TaskSetKey              string
-	TaskQueueName           string
-	TaskDeadLetterQueueName string
-	TaskBatchSize           string
-	VaultAddress            string
-	VaultStaticToken        string // this value will change each time a new vault -dev server is created
-	VaultStsSecret          string
-}
-
-func (c *ChymeConfig) String() string {
-	str, err := json.MarshalIndent(c, "  ", "  ")
-	if err != nil {
-		str = []byte("error marshaling struct: " + err.Error())
-	}
-	return fmt.Sprintf("\n==> Chyme configuration:\n\n  %s\n", string(str))
-}
-
-func loadConfigFromEnv() ChymeConfig {
-	return ChymeConfig{
-		WorkerWorkDir:           os.Getenv("CH_WORKER_WORKDIR"),
-		WorkerDockerUser:        os.Getenv("CH_WORKER_DOCKER_USER"),
-		WorkerDockerPull:        os.Getenv("CH_WORKER_DOCKER_PULL"),
-		WorkerDockerRemove:      os.Getenv("CH_WORKER_DOCKER_REMOVE"),
-		RedisAddress:            os.Getenv("CH_REDIS_ADDR"),
-		RedisPassword:           os.Getenv("CH_REDIS_PASSWORD"),
-		ResourceSetKey:          os.Getenv("CH_RESOURCE_SET"),
-		TaskSetKey:              os.Getenv("CH_TASK_SET"),
-		TaskQueueName:           os.Getenv("CH_TASK_QUEUE"),
-		TaskDeadLetterQueueName: os.Getenv("CH_TASK_DLQ"),
-		TaskBatchSize:           os.Getenv("CH_TASK_BATCH_SIZE"),
-		VaultAddress:            os.Getenv("CH_VAULT_ADDR"),
-		VaultStaticToken:        os.Getenv("CH_VAULT_STATIC_TKN"),
-		VaultStsSecret:          os.Getenv("CH_VAULT_STS_SECRET"),
-	}
-}
+// Levels and formats of the log.
+const (
+	logLevelDebug   = "debug"
+	logLevelInfo    = "info"
+	logLevelWarn    = "warn"
+	logLevelError   = "error"
+	logFormatLogfmt = "logfmt"
+	logFormatJSON   = "json"
+)
 
 func main() {
 
-	loglevel := level.AllowInfo()
-
+	// Settings in .env are read as environment variables when the configuration is loaded.
 	_ = godotenv.Load()
 
-	chConfig = loadConfigFromEnv()
+	// Replaced by the logger of the configuration once it is loaded.
+	logger, _ = newLogger(defaultConfig().Log)
+
+	// Execute prints the error itself.
+	if err := MainCmd.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
 
-	logger = log.NewLogfmtLogger(os.Stdout)
-	logger = level.NewFilter(logger, loglevel)
-	logger = log.With(logger, "ts", log.DefaultTimestampUTC)
-	level.Debug(logger).Log("msg", "Chyme Wave System starting")
+// Builds the logger of the log configuration. Every line has a timestamp and a level.
+func newLogger(c LogConfig) (log.Logger, error) {
+	var l log.Logger
+	switch c.Format {
+	case logFormatLogfmt, "":
+		l = log.NewLogfmtLogger(log.NewSyncWriter(os.Stdout))
+	case logFormatJSON:
+		l = log.NewJSONLogger(log.NewSyncWriter(os.Stdout))
+	default:
+		return nil, fmt.Errorf("invalid log format %s: must be %s or %s", c.Format, logFormatLogfmt, logFormatJSON)
+	}
+
+	var allow level.Option
+	switch c.Level {
+	case logLevelDebug:
+		allow = level.AllowDebug()
+	case logLevelInfo, "":
+		allow = level.AllowInfo()
+	case logLevelWarn:
+		allow = level.AllowWarn()
+	case logLevelError:
+		allow = level.AllowError()
+	default:
+		return nil, fmt.Errorf("invalid log level %s: must be %s, %s, %s or %s", c.Level,
+			logLevelDebug, logLevelInfo, logLevelWarn, logLevelError)
+	}
 
-	_ = MainCmd.Execute()
+	l = level.NewFilter(l, allow)
+	return log.With(l, "ts", log.DefaultTimestampUTC), nil
 }
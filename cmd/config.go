@@ -0,0 +1,498 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v2"
+	"kroekerlabs.dev/chyme/services/internal/core"
+	"kroekerlabs.dev/chyme/services/internal/tasker/template"
+	"kroekerlabs.dev/chyme/services/pkg/vault"
+)
+
+// Unified configuration for Chyme. Settings are read from the YAML file given by --config or CH_CONFIG, then from the
+// environment variables named by their env tags, then from the flags named by their path in the file, e.g.
+// --worker.docker.user, or by their flag tag. Settings tagged secret are redacted when the configuration is shown.
+type ChymeConfig struct {
+	Log     LogConfig     `yaml:"log"`
+	Tracing TracingConfig `yaml:"tracing"`
+	Redis   RedisConfig   `yaml:"redis"`
+	Tasks   TasksConfig   `yaml:"tasks"`
+	Worker  WorkerConfig  `yaml:"worker"`
+	Tasker  TaskerConfig  `yaml:"tasker"`
+	Indexer IndexerConfig `yaml:"indexer"`
+	AWS     AWSConfig     `yaml:"aws"`
+	Vault   VaultConfig   `yaml:"vault"`
+}
+
+type LogConfig struct {
+	// Least severe level logged: debug, info, warn or error.
+	Level string `yaml:"level" env:"CH_LOG_LEVEL" flag:"log-level"`
+	// Format of the log lines: logfmt or json.
+	Format string `yaml:"format" env:"CH_LOG_FORMAT" flag:"log-format"`
+}
+
+type TracingConfig struct {
+	// Exporter of the spans: none, otlp or stdout.
+	Exporter string `yaml:"exporter" env:"CH_TRACING_EXPORTER"`
+	// host:port of the OTLP/HTTP collector; the exporter's default, localhost:4318, unless set.
+	Endpoint string `yaml:"endpoint" env:"CH_OTLP_ENDPOINT"`
+	// Sends the spans over plain HTTP rather than HTTPS.
+	Insecure bool `yaml:"insecure" env:"CH_OTLP_INSECURE"`
+}
+
+type RedisConfig struct {
+	Address  string `yaml:"address" env:"CH_REDIS_ADDR"`
+	Password string `yaml:"password" env:"CH_REDIS_PASSWORD" secret:"true"`
+}
+
+// Redis sets and SQS queues the services share.
+type TasksConfig struct {
+	ResourceSet     string `yaml:"resourceSet" env:"CH_RESOURCE_SET"`
+	TaskSet         string `yaml:"taskSet" env:"CH_TASK_SET"`
+	Queue           string `yaml:"queue" env:"CH_TASK_QUEUE"`
+	DeadLetterQueue string `yaml:"deadLetterQueue" env:"CH_TASK_DLQ"`
+}
+
+type WorkerConfig struct {
+	WorkDir string `yaml:"workDir" env:"CH_WORKER_WORKDIR"`
+	// Identifies the worker in the labels of its containers; unless set, the hostname the worker first ran with, which
+	// is kept in the workdir.
+	ID             string              `yaml:"id" env:"CH_WORKER_ID"`
+	Docker         DockerConfig        `yaml:"docker"`
+	StopTimeout    time.Duration       `yaml:"stopTimeout" env:"CH_WORKER_DOCKER_STOP_TIMEOUT"`
+	ShutdownPolicy core.ShutdownPolicy `yaml:"shutdownPolicy" env:"CH_WORKER_SHUTDOWN_POLICY"`
+	// Enables the docker executor, which runs containers with the Docker daemon. Workers that only run containers
+	// with podman or the oci executor turn it off.
+	DockerExecutor bool `yaml:"dockerExecutor" env:"CH_WORKER_DOCKER_EXECUTOR"`
+	// Enables the process executor, which runs the commands of Tasks on the worker host.
+	ProcessExecutor bool     `yaml:"processExecutor" env:"CH_WORKER_PROCESS_EXECUTOR"`
+	ProcessEnv      []string `yaml:"processEnv" env:"CH_WORKER_PROCESS_ENV"`
+	PodmanHost      string   `yaml:"podmanHost" env:"CH_WORKER_PODMAN_HOST"`
+	OCIRuntime      string   `yaml:"ociRuntime" env:"CH_WORKER_OCI_RUNTIME"`
+	MountRoots      []string `yaml:"mountRoots" env:"CH_WORKER_MOUNT_ROOTS"`
+	SecretsDir      string   `yaml:"secretsDir" env:"CH_WORKER_SECRETS_DIR"`
+	// Address /metrics, /healthz, /readyz and /status are served at; none if empty.
+	ListenAddress string `yaml:"listenAddress" env:"CH_WORKER_LISTEN_ADDR"`
+}
+
+// Settings of the container executors.
+type DockerConfig struct {
+	User   string          `yaml:"user" env:"CH_WORKER_DOCKER_USER"`
+	Pull   core.PullPolicy `yaml:"pull" env:"CH_WORKER_DOCKER_PULL"`
+	Remove bool            `yaml:"remove" env:"CH_WORKER_DOCKER_REMOVE"`
+	// Set in the environment as key=value;key=value, e.g. cpus=2;memory=4g.
+	Limits    *core.LimitsConfig `yaml:"limits" env:"CH_WORKER_DOCKER_LIMITS"`
+	MaxLimits *core.LimitsConfig `yaml:"maxLimits" env:"CH_WORKER_DOCKER_MAX_LIMITS"`
+}
+
+type TaskerConfig struct {
+	BatchSize    int           `yaml:"batchSize" env:"CH_TASK_BATCH_SIZE"`
+	PollInterval time.Duration `yaml:"pollInterval" env:"CH_TASKER_POLL_INTERVAL"`
+	TemplateDir  string        `yaml:"templateDir" env:"CH_TEMPLATE_DIR"`
+	// Version of the Tasks created, labelled on their containers; a template file's version takes precedence.
+	TemplateVersion string `yaml:"templateVersion" env:"CH_TEMPLATE_VERSION"`
+	// Settings of the compiled-in templates by name, e.g. MOV. CH_TEMPLATE_<NAME>_* variables take precedence.
+	Templates map[string]template.Config `yaml:"templates"`
+	// Address /metrics is served at; none if empty.
+	ListenAddress string `yaml:"listenAddress" env:"CH_TASKER_LISTEN_ADDR"`
+}
+
+type IndexerConfig struct {
+	ListenAddress string `yaml:"listenAddress" env:"CH_INDEXER_LISTEN_ADDR"`
+	// URL of the indexer `indexer ingest` sends requests to.
+	URL string `yaml:"url" env:"CH_INDEXER_URL"`
+}
+
+type AWSConfig struct {
+	// Credential sources tried in order: vault, env, profile, instance.
+	Credentials      []string `yaml:"credentials" env:"CH_AWS_CREDENTIALS"`
+	Profile          string   `yaml:"profile" env:"CH_AWS_PROFILE"`
+	Region           string   `yaml:"region" env:"CH_AWS_REGION,AWS_REGION"`
+	CABundle         string   `yaml:"caBundle" env:"CH_AWS_CA_BUNDLE"`
+	TLSInsecure      bool     `yaml:"tlsInsecure" env:"CH_AWS_TLS_INSECURE"`
+	S3Endpoint       string   `yaml:"s3Endpoint" env:"CH_S3_ENDPOINT"`
+	S3ForcePathStyle bool     `yaml:"s3ForcePathStyle" env:"CH_S3_FORCE_PATH_STYLE"`
+	SQSEndpoint      string   `yaml:"sqsEndpoint" env:"CH_SQS_ENDPOINT"`
+}
+
+type VaultConfig struct {
+	Address        string        `yaml:"address" env:"CH_VAULT_ADDR"`
+	Token          string        `yaml:"token" env:"CH_VAULT_STATIC_TKN" secret:"true"`
+	STSSecret      string        `yaml:"stsSecret" env:"CH_VAULT_STS_SECRET"`
+	STSTTL         time.Duration `yaml:"stsTTL" env:"CH_VAULT_STS_TTL"`
+	RegistrySecret string        `yaml:"registrySecret" env:"CH_VAULT_REGISTRY_SECRET"`
+	TaskSecrets    string        `yaml:"taskSecrets" env:"CH_VAULT_TASK_SECRETS"`
+}
+
+func defaultConfig() ChymeConfig {
+	return ChymeConfig{
+		Log: LogConfig{
+			Level:  logLevelInfo,
+			Format: logFormatLogfmt,
+		},
+		Tracing: TracingConfig{
+			Exporter: tracingExporterNone,
+		},
+		Worker: WorkerConfig{
+			Docker: DockerConfig{
+				Pull:   core.PullNever,
+				Remove: true,
+			},
+			DockerExecutor: true,
+			StopTimeout:    core.DefaultStopGracePeriod,
+			ShutdownPolicy: core.ShutdownLeave,
+			ProcessEnv:     []string{"PATH", "HOME", "TMPDIR"},
+			SecretsDir:     core.DefaultSecretsDir,
+			ListenAddress:  ":9102",
+		},
+		Tasker: TaskerConfig{
+			PollInterval:    30 * time.Second,
+			TemplateVersion: "0.0.1",
+			ListenAddress:   ":9101",
+		},
+		Indexer: IndexerConfig{
+			ListenAddress: ":8080",
+			URL:           "http://localhost:8080",
+		},
+		AWS: AWSConfig{
+			Region:           defaultAwsRegion,
+			S3ForcePathStyle: true,
+		},
+		Vault: VaultConfig{
+			STSTTL: vault.DefaultSTSTTL,
+		},
+	}
+}
+
+// Commands the configuration is validated for, by the config annotation of their cobra.Command.
+const (
+	configAnnotation = "config"
+	forWorker        = "worker"
+	forTasker        = "tasker"
+	forIndexer       = "indexer"
+	forIngest        = "ingest"
+)
+
+var configCommands = []string{forWorker, forTasker, forIndexer, forIngest}
+
+// Loads the configuration from the file at path, if any, the environment and the flags that were set.
+func loadConfig(path string, flags *pflag.FlagSet) (ChymeConfig, error) {
+	c := defaultConfig()
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return c, fmt.Errorf("failed to read config file: %s", err.Error())
+		}
+		if err := yaml.UnmarshalStrict(data, &c); err != nil {
+			return c, fmt.Errorf("invalid config file %s: %s", path, err.Error())
+		}
+	}
+
+	errs := &multierror.Error{}
+	walkSettings(reflect.ValueOf(&c).Elem(), "", func(key string, field reflect.StructField, value reflect.Value) {
+		for _, env := range strings.Split(field.Tag.Get("env"), ",") {
+			if s := os.Getenv(env); env != "" && s != "" {
+				if err := setSetting(value, s); err != nil {
+					errs = multierror.Append(errs, fmt.Errorf("%s: %s", env, err.Error()))
+				}
+				break
+			}
+		}
+		if flag := flags.Lookup(settingFlag(key, field)); flag != nil && flag.Changed {
+			if err := setSetting(value, flag.Value.String()); err != nil {
+				errs = multierror.Append(errs, fmt.Errorf("--%s: %s", flag.Name, err.Error()))
+			}
+		}
+	})
+
+	// Policies are normalized, so that e.g. the boolean pull settings of older environments keep working.
+	var err error
+	if c.Worker.Docker.Pull, err = core.ParsePullPolicy(string(c.Worker.Docker.Pull)); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	if c.Worker.ShutdownPolicy, err = core.ParseShutdownPolicy(string(c.Worker.ShutdownPolicy)); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	return c, errs.ErrorOrNil()
+}
+
+// Calls fn with the key, field and value of each setting that has an env tag. Keys are the dotted YAML paths.
+func walkSettings(v reflect.Value, prefix string, fn func(key string, field reflect.StructField, value reflect.Value)) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		key := prefix + strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if field.Type.Kind() == reflect.Struct && field.Type != reflect.TypeOf(time.Duration(0)) {
+			walkSettings(v.Field(i), key+".", fn)
+			continue
+		}
+		if field.Tag.Get("env") != "" {
+			fn(key, field, v.Field(i))
+		}
+	}
+}
+
+// Name of the flag of a setting: its flag tag if it has one, otherwise its key.
+func settingFlag(key string, field reflect.StructField) string {
+	if flag := field.Tag.Get("flag"); flag != "" {
+		return flag
+	}
+	return key
+}
+
+// Sets a setting from its string form.
+func setSetting(v reflect.Value, s string) error {
+	switch v.Interface().(type) {
+	case time.Duration:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case *core.LimitsConfig:
+		limits, err := core.ParseLimitsConfig(s)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(limits))
+	case []string:
+		list := make([]string, 0)
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		v.Set(reflect.ValueOf(list))
+	default:
+		switch v.Kind() {
+		case reflect.String:
+			v.SetString(s)
+		case reflect.Bool:
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				return fmt.Errorf("invalid boolean %q", s)
+			}
+			v.SetBool(b)
+		case reflect.Int:
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				return fmt.Errorf("invalid number %q", s)
+			}
+			v.SetInt(int64(n))
+		default:
+			return fmt.Errorf("unsupported setting type %s", v.Type())
+		}
+	}
+	return nil
+}
+
+// Registers a flag for each setting of the configuration.
+func registerConfigFlags(flags *pflag.FlagSet) {
+	c := defaultConfig()
+	walkSettings(reflect.ValueOf(&c).Elem(), "", func(key string, field reflect.StructField, _ reflect.Value) {
+		flags.String(settingFlag(key, field), "", "overrides "+strings.Replace(field.Tag.Get("env"), ",", " and ", -1))
+	})
+}
+
+// Checks that the configuration has the settings the command needs. All missing or invalid settings are reported
+// together.
+func (c *ChymeConfig) Validate(command string) error {
+	errs := &multierror.Error{}
+	require := func(value string, setting string) {
+		errs = multierror.Append(errs, required(value, setting))
+	}
+	requireTasks := func(resourceSet bool) {
+		require(c.Redis.Address, "redis.address")
+		if resourceSet {
+			require(c.Tasks.ResourceSet, "tasks.resourceSet")
+		}
+		require(c.Tasks.TaskSet, "tasks.taskSet")
+		require(c.Tasks.Queue, "tasks.queue")
+		require(c.Tasks.DeadLetterQueue, "tasks.deadLetterQueue")
+	}
+
+	switch command {
+	case forWorker, forTasker, forIndexer:
+		errs = multierror.Append(errs, c.validateTracing())
+	}
+
+	switch command {
+	case forWorker:
+		requireTasks(false)
+		require(c.Worker.WorkDir, "worker.workDir")
+		if c.Worker.StopTimeout < 0 {
+			errs = multierror.Append(errs, errors.New("worker.stopTimeout must not be negative"))
+		}
+		errs = multierror.Append(errs, c.Worker.Docker.Limits.Validate(), c.Worker.Docker.MaxLimits.Validate())
+		if c.Vault.RegistrySecret != "" || c.Vault.TaskSecrets != "" {
+			require(c.Vault.Address, "vault.address")
+		}
+		errs = multierror.Append(errs, c.validateAWS())
+	case forTasker:
+		requireTasks(true)
+		if c.Tasker.BatchSize <= 0 {
+			errs = multierror.Append(errs, errors.New("tasker.batchSize must be positive"))
+		}
+		if c.Tasker.PollInterval <= 0 {
+			errs = multierror.Append(errs, errors.New("tasker.pollInterval must be positive"))
+		}
+		errs = multierror.Append(errs, c.validateAWS())
+	case forIndexer:
+		require(c.Redis.Address, "redis.address")
+		require(c.Tasks.ResourceSet, "tasks.resourceSet")
+		require(c.Indexer.ListenAddress, "indexer.listenAddress")
+		errs = multierror.Append(errs, c.validateAWS())
+	case forIngest:
+		require(c.Indexer.URL, "indexer.url")
+	default:
+		return fmt.Errorf("unknown command %s: must be one of %s", command, strings.Join(configCommands, ", "))
+	}
+
+	if err := errs.ErrorOrNil(); err != nil {
+		return fmt.Errorf("invalid %s configuration: %s", command, err.Error())
+	}
+	return nil
+}
+
+func (c *ChymeConfig) validateTracing() error {
+	switch c.Tracing.Exporter {
+	case tracingExporterNone, tracingExporterOTLP, tracingExporterStdout, "":
+		return nil
+	default:
+		return fmt.Errorf("invalid tracing exporter %s: must be %s, %s or %s", c.Tracing.Exporter,
+			tracingExporterNone, tracingExporterOTLP, tracingExporterStdout)
+	}
+}
+
+func (c *ChymeConfig) validateAWS() error {
+	errs := &multierror.Error{}
+	errs = multierror.Append(errs, required(c.AWS.Region, "aws.region"))
+	for _, source := range c.awsCredentialSources() {
+		switch source {
+		case credentialsVault:
+			errs = multierror.Append(errs, required(c.Vault.Address, "vault.address"))
+			errs = multierror.Append(errs, required(c.Vault.STSSecret, "vault.stsSecret"))
+		case credentialsEnv, credentialsProfile, credentialsInstance:
+		default:
+			errs = multierror.Append(errs, fmt.Errorf("invalid credential source %s: must be %s, %s, %s or %s",
+				source, credentialsVault, credentialsEnv, credentialsProfile, credentialsInstance))
+		}
+	}
+	return errs.ErrorOrNil()
+}
+
+func required(value string, setting string) error {
+	if value == "" {
+		return fmt.Errorf("%s is required", setting)
+	}
+	return nil
+}
+
+// Credential sources of the AWS session. Without any configured, credentials come from Vault if an STS secret is set,
+// and from the environment, shared profile or instance role otherwise.
+func (c *ChymeConfig) awsCredentialSources() []string {
+	if len(c.AWS.Credentials) > 0 {
+		return c.AWS.Credentials
+	}
+	if c.Vault.STSSecret != "" {
+		return []string{credentialsVault}
+	}
+	return []string{credentialsEnv, credentialsProfile, credentialsInstance}
+}
+
+// The configuration as YAML, with secrets redacted.
+func (c *ChymeConfig) String() string {
+	redacted := *c
+	walkSettings(reflect.ValueOf(&redacted).Elem(), "", func(_ string, field reflect.StructField, value reflect.Value) {
+		if field.Tag.Get("secret") == "true" && value.String() != "" {
+			value.SetString("REDACTED")
+		}
+	})
+	str, err := yaml.Marshal(&redacted)
+	if err != nil {
+		str = []byte("error marshaling struct: " + err.Error())
+	}
+	return fmt.Sprintf("\n==> Chyme configuration:\n\n%s", string(str))
+}
+
+// Loads the configuration before any command runs, validating it for the command's config annotation.
+func loadConfigForCommand(cmd *cobra.Command, _ []string) error {
+	path := configPath
+	if path == "" {
+		path = os.Getenv("CH_CONFIG")
+	}
+	// Errors of the configuration are not errors of the command line, so the usage is not printed for them.
+	cmd.SilenceUsage = true
+	c, err := loadConfig(path, cmd.Flags())
+	if err != nil {
+		return err
+	}
+	chConfig = c
+	if logger, err = newLogger(chConfig.Log); err != nil {
+		return err
+	}
+
+	if command, ok := cmd.Annotations[configAnnotation]; ok {
+		return chConfig.Validate(command)
+	}
+	return nil
+}
+
+var configPath string
+
+func init() {
+	MainCmd.PersistentFlags().StringVar(&configPath, "config", "", "configuration file (default $CH_CONFIG)")
+	registerConfigFlags(MainCmd.PersistentFlags())
+	MainCmd.PersistentPreRunE = loadConfigForCommand
+
+	configCmd.AddCommand(configShowCmd)
+	configCmd.AddCommand(configValidateCmd)
+
+	MainCmd.AddCommand(configCmd)
+}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect the Chyme configuration",
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Print the configuration, with secrets redacted.",
+	Run: func(_ *cobra.Command, args []string) {
+		fmt.Print(chConfig.String())
+	},
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:       "validate [command...]",
+	Short:     "Check the configuration for the given commands, or all of them.",
+	ValidArgs: configCommands,
+	Args:      cobra.OnlyValidArgs,
+	Run: func(_ *cobra.Command, args []string) {
+		if len(args) == 0 {
+			args = configCommands
+		}
+		valid := true
+		for _, command := range args {
+			if err := chConfig.Validate(command); err != nil {
+				fmt.Println(err)
+				valid = false
+			} else {
+				fmt.Println(command + ": configuration valid")
+			}
+		}
+		if !valid {
+			os.Exit(1)
+		}
+	},
+}
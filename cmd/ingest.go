@@ -6,10 +6,12 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/cobra"
 	"kroekerlabs.dev/chyme/services/internal/ingest"
 )
@@ -35,32 +37,37 @@ var (
 )
 
 var ingestStartCmd = &cobra.Command{
-	Use:   "start",
-	Short: "start listening at /ingest for ingest service requests",
+	Use:         "start",
+	Short:       "start listening at /ingest for ingest service requests",
+	Annotations: map[string]string{configAnnotation: forIndexer},
 	Run: func(cmd *cobra.Command, args []string) {
 		// fmt.Println("start ingest")
 		level.Debug(logger).Log("cmd", "start")
 		logger := log.With(logger, "svc", "ingest")
 
 		svc := buildService(logger)
+		_, err := startTracing(logger, "indexer")
+		CheckFatal(err)
 
 		ingestHandler := httptransport.NewServer(
 			ingest.MakeIngestEndpoint(svc),
 			ingest.DecodeIngestRequest,
 			ingest.EncodeIngestResponse,
+			httptransport.ServerBefore(ingest.ExtractTraceContext),
 		)
 
 		http.Handle("/ingest", ingestHandler)
-		level.Info(logger).Log("msg", "Listening", "transport", "http")
-		// TODO: make :8080 an env variable in chConfig: 'ingestListenPort'
-		http.ListenAndServe(":8080", nil)
+		http.Handle("/metrics", promhttp.Handler())
+		level.Info(logger).Log("msg", "Listening", "transport", "http", "addr", chConfig.Indexer.ListenAddress)
+		CheckFatal(http.ListenAndServe(chConfig.Indexer.ListenAddress, nil))
 	},
 }
 
 var ingestCmd = &cobra.Command{
-	Use:   "ingest",
-	Short: "ingest an S3 bucket to redis",
-	Args:  cobra.ExactArgs(1),
+	Use:         "ingest",
+	Short:       "ingest an S3 bucket to redis",
+	Args:        cobra.ExactArgs(1),
+	Annotations: map[string]string{configAnnotation: forIngest},
 	Run: func(cmd *cobra.Command, args []string) {
 		level.Debug(logger).Log("cmd", "ingest", "url", args[0])
 
@@ -71,20 +78,23 @@ var ingestCmd = &cobra.Command{
 		}
 
 		var buf bytes.Buffer
-		json.NewEncoder(&buf).Encode(req)
+		CheckFatal(json.NewEncoder(&buf).Encode(req))
 
-		res, err := http.Post("http://localhost:8080/ingest", "application/json", &buf)
+		res, err := http.Post(strings.TrimRight(chConfig.Indexer.URL, "/")+"/ingest", "application/json", &buf)
 		if err != nil {
-			errors.New("error making ingest request: " + err.Error())
+			CheckFatal(errors.New("error making ingest request: " + err.Error()))
 		}
+		defer res.Body.Close()
 		if res.StatusCode != 200 {
-			errors.New("response not ok: " + res.Status)
+			CheckFatal(errors.New("response not ok: " + res.Status))
 		}
 
 		var ingestResponse ingest.IngestResponse
-		json.NewDecoder(res.Body).Decode(&ingestResponse)
+		if err := json.NewDecoder(res.Body).Decode(&ingestResponse); err != nil {
+			CheckFatal(errors.New("invalid ingest response: " + err.Error()))
+		}
 		if ingestResponse.Err != "" {
-			errors.New("ingest failed: " + ingestResponse.Err)
+			CheckFatal(errors.New("ingest failed: " + ingestResponse.Err))
 		}
 
 		fmt.Println("Ingest Success")
@@ -92,20 +102,21 @@ var ingestCmd = &cobra.Command{
 }
 
 func buildService(logger log.Logger) ingest.IngestService {
-	awsSession := buildAwsSession()
+	awsSession, err := buildAwsSession()
+	CheckFatal(err)
 	s3Client := getS3Service(awsSession)
 	redisClient := getRedisClient()
 
-	resourceRepository := getResourceRepository(redisClient)
-	// TODO: create logging resource repository
-	// resourceRepository = core.NewLoggingResourceRepository(resourceRepository, logger)
+	resourceRepository := getResourceRepository(redisClient, logger)
 
-	setKey := chConfig.ResourceSetKey //os.Getenv("RESOURCE_SET_KEY")
+	setKey := chConfig.Tasks.ResourceSet
 
 	svc := ingest.New(ingest.Config{
 		ResourceRepository: resourceRepository,
 		ResourceSetKey:     setKey,
 		S3:                 s3Client,
+		Logger:             logger,
+		Metrics:            ingestMetrics(),
 	})
 
 	return svc
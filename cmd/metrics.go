@@ -0,0 +1,132 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"kroekerlabs.dev/chyme/services/internal/core"
+	"kroekerlabs.dev/chyme/services/internal/ingest"
+	"kroekerlabs.dev/chyme/services/internal/tasker"
+	"kroekerlabs.dev/chyme/services/internal/worker"
+)
+
+// Namespace of the Prometheus metrics.
+const metricsNamespace = "chyme"
+
+// Serves /metrics, and whatever else is registered with http.DefaultServeMux, at addr. An empty addr serves nothing.
+func serveMetrics(logger log.Logger, addr string) {
+	if addr == "" {
+		return
+	}
+	http.Handle("/metrics", promhttp.Handler())
+	go func() {
+		level.Info(logger).Log("msg", "Listening", "transport", "http", "addr", addr)
+		CheckFatal(http.ListenAndServe(addr, nil))
+	}()
+}
+
+func ingestMetrics() *ingest.Metrics {
+	counter := func(name string, help string) *kitprometheus.Counter {
+		return kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "ingest",
+			Name:      name,
+			Help:      help,
+		}, []string{"bucket"})
+	}
+	return &ingest.Metrics{
+		ObjectsListed:   counter("objects_listed_total", "Objects listed under ingested prefixes."),
+		ObjectsMatched:  counter("objects_matched_total", "Listed objects the ingest filter matched."),
+		ObjectsInserted: counter("objects_inserted_total", "Matched objects inserted into the resource set."),
+		ResourceSetSize: kitprometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "ingest",
+			Name:      "resource_set_size",
+			Help:      "Resources in the resource set after the last ingest.",
+		}, []string{}),
+	}
+}
+
+func taskerMetrics() *tasker.Metrics {
+	return &tasker.Metrics{
+		TasksCreated: kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "tasker",
+			Name:      "tasks_created_total",
+			Help:      "Tasks created and enqueued.",
+		}, []string{"template"}),
+		ResourcesRejected: kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "tasker",
+			Name:      "resources_rejected_total",
+			Help:      "Resources moved to the reject set because their tasks could not be created.",
+		}, []string{}),
+		ResourceSetSize: kitprometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "tasker",
+			Name:      "resource_set_size",
+			Help:      "Resources waiting in the resource set.",
+		}, []string{}),
+		QueueDepth: kitprometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "tasker",
+			Name:      "queue_depth",
+			Help:      "Approximate number of messages waiting in the task queue.",
+		}, []string{}),
+	}
+}
+
+func workerMetrics() *worker.Metrics {
+	return &worker.Metrics{
+		TasksInProcess: kitprometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "worker",
+			Name:      "tasks_in_process",
+			Help:      "Tasks being processed.",
+		}, []string{}),
+		TasksProcessed: kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "worker",
+			Name:      "tasks_processed_total",
+			Help:      "Tasks processed, by outcome: complete, failed, interrupted or retried.",
+		}, []string{"template", "outcome"}),
+		StageDuration: kitprometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "worker",
+			Name:      "stage_duration_seconds",
+			Help:      "Seconds each stage of processing a task took.",
+			Buckets:   stdprometheus.ExponentialBuckets(0.5, 2, 14),
+		}, []string{"stage"}),
+		Exits: kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "worker",
+			Name:      "exits_total",
+			Help:      "Exits of task containers and commands, by exit code.",
+		}, []string{"executor", "code"}),
+		DeadLetters: kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "worker",
+			Name:      "dead_letters_total",
+			Help:      "Failed tasks sent to the dead letter queue.",
+		}, []string{"template"}),
+	}
+}
+
+func loaderMetrics() *core.LoaderMetrics {
+	counter := func(name string, help string) *kitprometheus.Counter {
+		return kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "worker",
+			Name:      name,
+			Help:      help,
+		}, []string{"template"})
+	}
+	return &core.LoaderMetrics{
+		BytesDownloaded: counter("downloaded_bytes_total", "Bytes of task inputs downloaded."),
+		BytesUploaded:   counter("uploaded_bytes_total", "Bytes of task outputs and metadata uploaded."),
+	}
+}
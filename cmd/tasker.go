@@ -1,13 +1,13 @@
 package main
 
 import (
-	"fmt"
 	"os"
 	"os/signal"
-	"strconv"
 	"syscall"
 	"time"
 
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
 	"github.com/spf13/cobra"
 	"kroekerlabs.dev/chyme/services/internal/core"
 	"kroekerlabs.dev/chyme/services/internal/tasker"
@@ -26,38 +26,41 @@ var taskerCommand = &cobra.Command{
 }
 
 var taskerStartCmd = &cobra.Command{
-	Use:   "start",
-	Short: "Start the Tasker service.",
+	Use:         "start",
+	Short:       "Start the Tasker service.",
+	Annotations: map[string]string{configAnnotation: forTasker},
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("starting tasker")
+		logger := log.With(logger, "svc", "tasker")
+		level.Info(logger).Log("msg", "starting tasker")
 		redis := getRedisClient()
-		resourceRepository := getResourceRepository(redis)
+		resourceRepository := getResourceRepository(redis, logger)
 
-		taskRepository := core.NewRedisTaskRepository(redis, chConfig.TaskSetKey)
+		taskRepository := core.NewRedisTaskRepository(redis, chConfig.Tasks.TaskSet)
 
-		sess := buildAwsSession()
+		sess, err := buildAwsSession()
+		CheckFatal(err)
 
 		sqs := getSQSService(sess)
-		sqsQueue := getSQSQueue(sqs, chConfig.TaskQueueName)
-		dlq := getSQSQueue(sqs, chConfig.TaskDeadLetterQueueName)
+		sqsQueue := getSQSQueue(sqs, chConfig.Tasks.Queue)
+		dlq := getSQSQueue(sqs, chConfig.Tasks.DeadLetterQueue)
 		taskQueue := core.NewSQSTaskQueue(sqsQueue, dlq)
 
 		templater := buildTemplater()
 
-		batchSize, err := strconv.Atoi(chConfig.TaskBatchSize)
-		if err != nil {
-			fmt.Println("Fatal: " + err.Error())
-			os.Exit(1)
-		}
-
 		svc := tasker.New(&tasker.Config{
-			ResourceSetKey:     chConfig.ResourceSetKey,
+			ResourceSetKey:     chConfig.Tasks.ResourceSet,
 			ResourceRepository: resourceRepository,
 			TaskRepository:     taskRepository,
 			TaskQueue:          taskQueue,
 			Templater:          templater,
-			BatchSize:          batchSize,
+			BatchSize:          chConfig.Tasker.BatchSize,
+			Logger:             logger,
+			Metrics:            taskerMetrics(),
 		})
+		serveMetrics(logger, chConfig.Tasker.ListenAddress)
+		stopTracing, err := startTracing(logger, "tasker")
+		CheckFatal(err)
+		defer stopTracing()
 
 		/*
 		 * GOLANG CHANNELS
@@ -69,11 +72,14 @@ var taskerStartCmd = &cobra.Command{
 		sigCh := make(chan os.Signal, 1)
 		// causes package signal to relay incoming signals to channel
 		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+		// SIGHUP reloads the templates without restarting the tasker
+		hupCh := make(chan os.Signal, 1)
+		signal.Notify(hupCh, syscall.SIGHUP)
 
 		/*
 		 * GOROUTINE
 		 */
-		go doneOnSignal(doneCh, sigCh)
+		go doneOnSignal(logger, doneCh, sigCh)
 
 		/*
 		 * SELECT STATEMENT
@@ -81,18 +87,25 @@ var taskerStartCmd = &cobra.Command{
 		 * the select statement blocks until one of its cases can run
 		 */
 
-		// TODO: make ticker interval configurable
-		ticker := time.NewTicker(time.Second * 30)
+		ticker := time.NewTicker(chConfig.Tasker.PollInterval)
 		for {
 			select {
 			case <-doneCh:
-				fmt.Println("done")
+				level.Info(logger).Log("msg", "tasker stopped")
 				ticker.Stop()
 				return
+			case <-hupCh:
+				level.Info(logger).Log("msg", "reloading templates")
+				if err := templater.Reload(); err != nil {
+					level.Error(logger).Log("msg", "failed to reload templates, keeping previous templates", "err", err)
+				}
 			case <-ticker.C:
-				fmt.Println("tick...")
+				level.Debug(logger).Log("msg", "tick")
+				if !credentialsAvailable(logger, sess) {
+					continue
+				}
 				if err := svc.Poll(); err != nil {
-					fmt.Println(fmt.Errorf("error: %s", err))
+					level.Error(logger).Log("msg", "failed to create tasks", "err", err)
 				}
 			}
 		}
@@ -100,13 +113,29 @@ var taskerStartCmd = &cobra.Command{
 }
 
 func buildTemplater() tasker.Templater {
+	// Templates defined in CH_TEMPLATE_DIR replace the compiled-in templates.
+	if chConfig.Tasker.TemplateDir != "" {
+		templater, err := tasker.NewFileTemplater(chConfig.Tasker.TemplateDir, chConfig.Tasker.TemplateVersion)
+		CheckFatal(err)
+		return templater
+	}
+
+	// Template configuration is read from the config file and CH_TEMPLATE_<NAME>_* once, here, so bad values stop the tasker at startup.
+	movConfig, err := template.LoadConfig("MOV", chConfig.Tasker.Templates["MOV"].Or(template.MovDefaults))
+	CheckFatal(err)
+	mp4Config, err := template.LoadConfig("MP4", chConfig.Tasker.Templates["MP4"].Or(template.Mp4Defaults))
+	CheckFatal(err)
+
 	// Register additional templates here.
-	return tasker.NewInMemTemplater([]*tasker.Template{
-		// template.Mie4NitfV2,
-		template.Mov,
-		template.Mp4,
+	templates := []*tasker.Template{
+		// template.Mie4NitfV2(mie4nitfConfig),
+		template.Mov(movConfig),
+		template.Mp4(mp4Config),
 		// template.MEI4NITFChunked,
 		// template.MP2TS,
 		// template.MEI4NITFBreadcrumb,
-	}, "0.0.1")
+	}
+	CheckFatal(tasker.ValidateTemplates(templates))
+
+	return tasker.NewInMemTemplater(templates, chConfig.Tasker.TemplateVersion)
 }
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+)
+
+// Exporters of the spans.
+const (
+	tracingExporterNone   = "none"
+	tracingExporterOTLP   = "otlp"
+	tracingExporterStdout = "stdout"
+)
+
+// Time the spans still buffered at shutdown are given to be exported.
+const tracingShutdownTimeout = 5 * time.Second
+
+// Registers the tracer provider of the tracing configuration for the service, e.g. worker, and returns a func that
+// exports the spans still buffered. The trace context of Tasks and resources is propagated even if spans are not
+// exported, so that the services further on can continue their traces.
+func startTracing(logger log.Logger, service string) (func(), error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch chConfig.Tracing.Exporter {
+	case tracingExporterNone, "":
+		return func() {}, nil
+	case tracingExporterOTLP:
+		var opts []otlptracehttp.Option
+		if chConfig.Tracing.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(chConfig.Tracing.Endpoint))
+		}
+		if chConfig.Tracing.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(context.Background(), opts...)
+	case tracingExporterStdout:
+		// Spans are written to stderr so as not to interleave with the log.
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stderr))
+	default:
+		return nil, fmt.Errorf("invalid tracing exporter %s", chConfig.Tracing.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s span exporter: %s", chConfig.Tracing.Exporter, err.Error())
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceNameKey.String("chyme-"+service),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+	level.Info(logger).Log("msg", "exporting spans", "exporter", chConfig.Tracing.Exporter)
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+		defer cancel()
+		if err := provider.Shutdown(ctx); err != nil {
+			level.Warn(logger).Log("msg", "failed to export buffered spans", "err", err)
+		}
+	}, nil
+}
@@ -1,87 +1,173 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"net/http"
 	"os"
+	"strings"
 
 	"docker.io/go-docker"
+	dockerapi "docker.io/go-docker/api"
 	"kroekerlabs.dev/chyme/services/internal/core"
 	"kroekerlabs.dev/chyme/services/pkg/aws"
+	"kroekerlabs.dev/chyme/services/pkg/vault"
 	amzaws "github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/defaults"
+	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/sqs"
-	"github.com/hashicorp/vault/api"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
 	"github.com/go-redis/redis"
 )
 
 /* Resource Builders */
 
-func buildAwsSession() *session.Session {
-	config := &api.Config{
-		Address: chConfig.VaultAddress,
-	}
+func getVaultClient() (*vault.Client, error) {
+	return vault.NewClient(chConfig.Vault.Address, chConfig.Vault.Token)
+}
 
-	client, err := api.NewClient(config)
-	if err != nil {
-		fmt.Println("New Vault Client Fatal: " + err.Error())
-		return nil
+// Sources of AWS credentials, tried in the order CH_AWS_CREDENTIALS lists them.
+const (
+	// STS credentials issued by Vault from CH_VAULT_STS_SECRET.
+	credentialsVault = "vault"
+	// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN.
+	credentialsEnv = "env"
+	// A profile of the shared credentials file, CH_AWS_PROFILE or default.
+	credentialsProfile = "profile"
+	// The role of the EC2 instance or ECS task.
+	credentialsInstance = "instance"
+)
+
+// Region of the AWS services unless CH_AWS_REGION or AWS_REGION is set.
+const defaultAwsRegion = "us-east-1"
+
+// Builds the AWS session of the services from the credential sources of CH_AWS_CREDENTIALS. Without it, credentials
+// come from Vault if CH_VAULT_STS_SECRET is set, and from the environment, shared profile or instance role otherwise.
+// Credentials are retrieved once here, so that a misconfigured source fails at startup.
+func buildAwsSession() (*session.Session, error) {
+	sources := chConfig.awsCredentialSources()
+
+	providers := make([]credentials.Provider, 0, len(sources))
+	for _, source := range sources {
+		provider, err := credentialProvider(source)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, provider)
 	}
-	client.SetToken(chConfig.VaultStaticToken)
-	c := client.Logical()
-	options := map[string]interface{}{
-		"ttl": "30m",
+	creds := credentials.NewCredentials(&credentials.ChainProvider{Providers: providers, VerboseErrors: true})
+	if _, err := creds.Get(); err != nil {
+		return nil, fmt.Errorf("no AWS credentials from %s: %s", strings.Join(sources, ", "), err.Error())
 	}
-	s, err := c.Write(chConfig.VaultStsSecret, options)
+
+	httpClient, err := awsHTTPClient()
 	if err != nil {
-		fmt.Println("Write Secret Fatal: " + err.Error())
-		return nil
+		return nil, err
 	}
+	return session.NewSession(&amzaws.Config{
+		Credentials: creds,
+		MaxRetries:  amzaws.Int(3),
+		Region:      amzaws.String(chConfig.AWS.Region),
+		HTTPClient:  httpClient,
+	})
+}
 
-	// pull relevant information from assumed role to create AWS session
-
-	key := s.Data["access_key"].(string)
-	secret := s.Data["secret_key"].(string)
-	token := s.Data["security_token"].(string)
+// HTTP client of the AWS services, trusting the CAs of CH_AWS_CA_BUNDLE in addition to the system's, and skipping
+// certificate verification if CH_AWS_TLS_INSECURE is set, e.g. for a local MinIO with a self-signed certificate.
+func awsHTTPClient() (*http.Client, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: chConfig.AWS.TLSInsecure,
+	}
+	if chConfig.AWS.CABundle != "" {
+		pem, err := ioutil.ReadFile(chConfig.AWS.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %s", err.Error())
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in CA bundle %s", chConfig.AWS.CABundle)
+		}
+		tlsConfig.RootCAs = pool
+	}
 
-	creds := credentials.NewStaticCredentials(key, secret, token)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: transport}, nil
+}
 
-	sess := session.Must(session.NewSession(&amzaws.Config{
-		Credentials: creds,
-		MaxRetries: amzaws.Int(3),
-		Region: amzaws.String("us-east-1"),
-	}))
+func credentialProvider(source string) (credentials.Provider, error) {
+	switch source {
+	case credentialsVault:
+		if chConfig.Vault.STSSecret == "" {
+			return nil, errors.New("credential source vault requires CH_VAULT_STS_SECRET")
+		}
+		client, err := getVaultClient()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Vault client: %s", err.Error())
+		}
+		return vault.NewSTSProvider(client, chConfig.Vault.STSSecret, chConfig.Vault.STSTTL, logger), nil
+	case credentialsEnv:
+		return &credentials.EnvProvider{}, nil
+	case credentialsProfile:
+		return &credentials.SharedCredentialsProvider{Profile: chConfig.AWS.Profile}, nil
+	case credentialsInstance:
+		return defaults.RemoteCredProvider(*defaults.Config(), defaults.Handlers()), nil
+	default:
+		return nil, fmt.Errorf("invalid credential source %s: must be %s, %s, %s or %s",
+			source, credentialsVault, credentialsEnv, credentialsProfile, credentialsInstance)
+	}
+}
 
-	return sess
+// Reports whether the session has valid credentials, so that services can pause polling while Vault is unreachable
+// rather than fail every request.
+func credentialsAvailable(logger log.Logger, sess *session.Session) bool {
+	if _, err := sess.Config.Credentials.Get(); err != nil {
+		level.Warn(logger).Log("msg", "AWS credentials unavailable, pausing", "err", err)
+		return false
+	}
+	return true
 }
 
+// Creates the S3 client. CH_S3_ENDPOINT points it at S3-compatible storage such as MinIO or Ceph RGW, which is
+// addressed path-style (http://host/bucket/key) unless CH_S3_FORCE_PATH_STYLE is false.
 func getS3Service(sess *session.Session) *s3.S3 {
-	endpoint := "" // this should be an environment variable (look into use of github.com/joho/godotenv)
-	return s3.New(sess, &amzaws.Config{
-		Endpoint: amzaws.String(endpoint),
-	})
+	config := &amzaws.Config{}
+	if chConfig.AWS.S3Endpoint != "" {
+		config.Endpoint = amzaws.String(chConfig.AWS.S3Endpoint)
+		config.S3ForcePathStyle = amzaws.Bool(chConfig.AWS.S3ForcePathStyle)
+	}
+	return s3.New(sess, config)
 }
 
+// Creates the SQS client. CH_SQS_ENDPOINT points it at an SQS-compatible service, e.g. a local emulator.
 func getSQSService(sess *session.Session) *sqs.SQS {
-	endpoint := "" // this should be an environment variable
-	return sqs.New(sess, &amzaws.Config{
-		Endpoint: amzaws.String(endpoint),
-	})
+	config := &amzaws.Config{}
+	if chConfig.AWS.SQSEndpoint != "" {
+		config.Endpoint = amzaws.String(chConfig.AWS.SQSEndpoint)
+	}
+	return sqs.New(sess, config)
 }
 
 func getSQSQueue(client *sqs.SQS, name string) *aws.SqsQueue {
 	q, err := aws.NewSQSQueue(client, name)
 	if err != nil {
-		fmt.Println("Fatal: " + err.Error())
-		os.Exit(1)
+		CheckFatal(fmt.Errorf("could not open queue %s: %s", name, err.Error()))
 	}
 	return q
 }
 
 func getRedisClient() *redis.Client {
-	redisAddr := chConfig.RedisAddress //"localhost:6379"
-	redisPwd  := chConfig.RedisPassword //"" // no password set on dev redis-server
+	redisAddr := chConfig.Redis.Address //"localhost:6379"
+	redisPwd  := chConfig.Redis.Password //"" // no password set on dev redis-server
 
 	cli := redis.NewClient(&redis.Options{
 		Addr:     redisAddr,
@@ -92,31 +178,59 @@ func getRedisClient() *redis.Client {
 	return cli
 }
 
-func getResourceRepository(client *redis.Client) core.ResourceRepository {
-	return core.NewRedisResourceRepository(client)
+func getResourceRepository(client *redis.Client, logger log.Logger) core.ResourceRepository {
+	return core.NewRedisResourceRepository(client, logger)
+}
+
+// Returns the credentials images are pulled with, or nil if no Vault path for them is configured.
+func getRegistryAuth() core.RegistryAuth {
+	if chConfig.Vault.RegistrySecret == "" {
+		return nil
+	}
+	client, err := getVaultClient()
+	CheckFatal(err)
+	return core.NewVaultRegistryAuth(client, chConfig.Vault.RegistrySecret)
+}
+
+// Returns the resolver of task secrets, or nil if no Vault path they may be read from is configured.
+func getSecretResolver() core.SecretResolver {
+	if chConfig.Vault.TaskSecrets == "" {
+		return nil
+	}
+	client, err := getVaultClient()
+	CheckFatal(err)
+	return core.NewVaultSecretResolver(client, chConfig.Vault.TaskSecrets)
 }
 
 func getDockerClient() *docker.Client {
 	cli, err := docker.NewEnvClient()
 	if err != nil {
-		fmt.Println(fmt.Errorf("Could not connect to Docker: %s", err))
-		os.Exit(1)
+		CheckFatal(fmt.Errorf("could not connect to Docker: %s", err.Error()))
+	}
+	return cli
+}
+
+// Client of a Podman API socket, e.g. unix:///run/podman/podman.sock, which serves the Docker API.
+func getPodmanClient(host string) *docker.Client {
+	cli, err := docker.NewClient(host, dockerapi.DefaultVersion, nil, nil)
+	if err != nil {
+		CheckFatal(fmt.Errorf("could not connect to Podman: %s", err.Error()))
 	}
 	return cli
 }
 
 /* Signal handling */
 
-func doneOnSignal(doneCh chan<- bool, sigCh <-chan os.Signal) {
+func doneOnSignal(logger log.Logger, doneCh chan<- bool, sigCh <-chan os.Signal) {
 	sig := <-sigCh
-	// level.Info(logger).Log("msg", "Caught signal, terminating gracefully.", "signal", sig)
-	fmt.Println(fmt.Errorf("Caught signal %s , terminating gracefully", sig))
+	level.Info(logger).Log("msg", "Caught signal, terminating gracefully.", "signal", sig)
 	doneCh <- true
 }
 
 func CheckFatal(err error) {
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "fatal: %s\n", err.Error())
+		// Logged rather than printed, so that the line has the configured format.
+		level.Error(logger).Log("msg", "fatal", "err", err)
 		os.Exit(1)
 	}
 }
\ No newline at end of file
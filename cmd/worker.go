@@ -3,21 +3,28 @@ package main
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
+	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
-	"strconv"
+	"strings"
 	"syscall"
+	"text/tabwriter"
 	"time"
 
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
 	"github.com/spf13/cobra"
 	"kroekerlabs.dev/chyme/services/internal/core"
 	"kroekerlabs.dev/chyme/services/internal/worker"
+	"kroekerlabs.dev/chyme/services/internal/worker/executable"
 	"kroekerlabs.dev/chyme/services/internal/worker/hooks"
 )
 
 func init() {
 	workerCmd.AddCommand(workerStartCmd)
+	workerCmd.AddCommand(workerContainersCmd)
 
 	MainCmd.AddCommand(workerCmd)
 }
@@ -28,11 +35,14 @@ var workerCmd = &cobra.Command{
 }
 
 var workerStartCmd = &cobra.Command{
-	Use:   "start",
-	Short: "Start the Worker service.",
+	Use:         "start",
+	Short:       "Start the Worker service.",
+	Annotations: map[string]string{configAnnotation: forWorker},
 	Run: func(_ *cobra.Command, args []string) {
+		logger := log.With(logger, "svc", "worker")
 
-		sess := buildAwsSession()
+		sess, err := buildAwsSession()
+		CheckFatal(err)
 
 		/* TODO: might need new sts role for executing tasks in docker?
 		 *       look into using sts from aws-go-sdk rather than vault?
@@ -43,105 +53,277 @@ var workerStartCmd = &cobra.Command{
 
 		var defaultMetadata map[string]*string
 
-		s3Loader := core.NewS3ResourceLoader(s3Client, defaultMetadata)
+		s3Loader := core.NewS3ResourceLoader(s3Client, defaultMetadata, logger)
 		resourceLoader := core.NewResourceLoader(map[string]core.ResourceLoader{s3Loader.Scheme(): s3Loader})
 
-		workdir := filepath.Join(chConfig.WorkerWorkDir, "chyme")
+		workdir := filepath.Join(chConfig.Worker.WorkDir, "chyme")
 
-		taskLoader := core.NewTaskLoader(resourceLoader, workdir)
+		taskLoader := core.NewTaskLoader(resourceLoader, workdir, logger, loaderMetrics())
+
+		id, err := workerID(workdir)
+		CheckFatal(err)
 
 		// SQS queue stuff
 
 		sqs := getSQSService(sess)
-		sqsQueue := getSQSQueue(sqs, chConfig.TaskQueueName)
-		dlq := getSQSQueue(sqs, chConfig.TaskDeadLetterQueueName)
+		sqsQueue := getSQSQueue(sqs, chConfig.Tasks.Queue)
+		dlq := getSQSQueue(sqs, chConfig.Tasks.DeadLetterQueue)
 		taskQueue := core.NewSQSTaskQueue(sqsQueue, dlq)
 
-		// Docker stuff
+		// Follow-up tasks of a chain are recorded in the task repository as they are enqueued
 
-		dockerClient := getDockerClient()
+		taskRepository := core.NewRedisTaskRepository(getRedisClient(), chConfig.Tasks.TaskSet)
 
 		// Make executors
 
-		dockerTaskExecutor := core.NewDockerTaskExecutor(
-			dockerClient,
-			chConfig.WorkerDockerUser,
-			parseBoolOption(chConfig.WorkerDockerPull, false),
-			parseBoolOption(chConfig.WorkerDockerRemove, true),
-		)
-		// inMemExecutor := core.NewInMemTaskExecutor(map[string]core.InmemExecutable{
-		//	"BreadcrumbUpdate": &executable.BreadcrumbUpdate{S3: s3Client}
-		// }, logger)
-		taskExecutor := core.NewTaskExecutor(map[string]core.TaskExecutor{
-			dockerTaskExecutor.Name(): dockerTaskExecutor,
-			// inMemExecutor.Name():      inMemExecutor,
+		// Container executors share the worker's container settings
+		containerConfig := func() *core.DockerExecutorConfig {
+			return &core.DockerExecutorConfig{
+				WorkerID:        id,
+				User:            chConfig.Worker.Docker.User,
+				PullPolicy:      chConfig.Worker.Docker.Pull,
+				RegistryAuth:    getRegistryAuth(),
+				Secrets:         getSecretResolver(),
+				SecretsDir:      chConfig.Worker.SecretsDir,
+				ShouldRemove:    chConfig.Worker.Docker.Remove,
+				DefaultLimits:   chConfig.Worker.Docker.Limits.ResourceLimits(),
+				MaxLimits:       chConfig.Worker.Docker.MaxLimits.ResourceLimits(),
+				MountRoots:      chConfig.Worker.MountRoots,
+				StopGracePeriod: chConfig.Worker.StopTimeout,
+				ShutdownPolicy:  chConfig.Worker.ShutdownPolicy,
+				Logger:          logger,
+			}
+		}
+		inMemExecutor := core.NewInMemTaskExecutor(map[string]core.InmemExecutable{
+			"Manifest": executable.Manifest{},
 		})
+		executors := map[string]core.TaskExecutor{
+			inMemExecutor.Name(): inMemExecutor,
+		}
+
+		// Commands run on the host only on workers that opt in; elsewhere process Tasks fail as of an unknown executor
+
+		if chConfig.Worker.ProcessExecutor {
+			processTaskExecutor := core.NewProcessTaskExecutor(&core.ProcessExecutorConfig{
+				InheritEnv:      chConfig.Worker.ProcessEnv,
+				StopGracePeriod: chConfig.Worker.StopTimeout,
+				Logger:          logger,
+			})
+			executors[processTaskExecutor.Name()] = processTaskExecutor
+		}
+
+		// Readiness of the worker: the services it needs to process Tasks are reachable
+
+		checks := map[string]worker.Check{
+			"queue": func(ctx context.Context) error {
+				_, err := taskQueue.MessageCountWithContext(ctx)
+				return err
+			},
+			// Cached credentials are only checked for expiry; expired ones must be retrieved again within the probe.
+			"credentials": func(ctx context.Context) error {
+				if !sess.Config.Credentials.IsExpired() {
+					return nil
+				}
+				_, err := sess.Config.Credentials.GetWithContext(ctx)
+				return err
+			},
+		}
+
+		// Workers that only run containers with podman or the oci executor need no Docker daemon
+
+		if chConfig.Worker.DockerExecutor {
+			dockerClient := getDockerClient()
+			dockerTaskExecutor := core.NewDockerTaskExecutor(dockerClient, containerConfig())
+			executors[dockerTaskExecutor.Name()] = dockerTaskExecutor
+			checks["docker"] = func(ctx context.Context) error {
+				_, err := dockerClient.Ping(ctx)
+				return err
+			}
+		}
+
+		// Hosts without a Docker daemon run containers with Podman, through its API socket or a runtime CLI
+
+		if chConfig.Worker.PodmanHost != "" {
+			podmanConfig := containerConfig()
+			podmanConfig.Name = "podman"
+			podmanClient := getPodmanClient(chConfig.Worker.PodmanHost)
+			podmanTaskExecutor := core.NewDockerTaskExecutor(podmanClient, podmanConfig)
+			executors[podmanTaskExecutor.Name()] = podmanTaskExecutor
+			checks["podman"] = func(ctx context.Context) error {
+				_, err := podmanClient.Ping(ctx)
+				return err
+			}
+		}
+		if chConfig.Worker.OCIRuntime != "" {
+			config := containerConfig()
+			ociTaskExecutor := core.NewOCITaskExecutor(&core.OCIExecutorConfig{
+				Runtime:         chConfig.Worker.OCIRuntime,
+				WorkerID:        config.WorkerID,
+				User:            config.User,
+				PullPolicy:      config.PullPolicy,
+				ShouldRemove:    config.ShouldRemove,
+				DefaultLimits:   config.DefaultLimits,
+				MaxLimits:       config.MaxLimits,
+				MountRoots:      config.MountRoots,
+				RegistryAuth:    config.RegistryAuth,
+				Secrets:         config.Secrets,
+				SecretsDir:      config.SecretsDir,
+				StopGracePeriod: config.StopGracePeriod,
+				ShutdownPolicy:  config.ShutdownPolicy,
+				Logger:          config.Logger,
+			})
+			executors[ociTaskExecutor.Name()] = ociTaskExecutor
+		}
+
+		taskExecutor := core.NewTaskExecutor(executors)
 
 		persister := worker.NewFSPersister(workdir)
 
 		// Create the service
 
 		svc := worker.New(&worker.Config{
-			TaskQueue:    taskQueue,
-			TaskLoader:   taskLoader,
-			TaskExecutor: taskExecutor,
-			Persister:    persister,
+			TaskQueue:      taskQueue,
+			TaskRepository: taskRepository,
+			TaskLoader:     taskLoader,
+			TaskExecutor:   taskExecutor,
+			Persister:      persister,
 			Hooks: map[string]hooks.Interface{
-				"mov": &hooks.MOV{
+				core.HooksNone: &hooks.Base{},
+				core.HooksMOV: &hooks.MOV{
 					TaskLoader:     taskLoader,
 					ResourceLoader: resourceLoader,
+					Logger:         logger,
 				},
-				"mp4": &hooks.MP4{
+				core.HooksMP4: &hooks.MP4{
 					TaskLoader:     taskLoader,
 					ResourceLoader: resourceLoader,
+					Logger:         logger,
 				},
 			},
 			Version: "0.1.0",
+			Logger:  logger,
+			Metrics: workerMetrics(),
 		})
+		worker.HandleHTTP(http.DefaultServeMux, svc, checks)
+		serveMetrics(logger, chConfig.Worker.ListenAddress)
+		stopTracing, err := startTracing(logger, "worker")
+		CheckFatal(err)
 
 		// Channels
 
 		sigCh := make(chan os.Signal, 1)
 		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 		ctx, cancel := context.WithCancel(context.Background())
-		go cancelOnSignal(cancel, sigCh)
+		go cancelOnSignal(logger, cancel, sigCh)
 
 		errCh := make(chan error)
 		go func() {
-			err := <-errCh
-			fmt.Println(fmt.Errorf("SEVERE: unrecoverable error while processing Task: %s", err))
+			for err := range errCh {
+				level.Error(logger).Log("msg", "unrecoverable error while processing task", "err", err)
+			}
 		}()
 
+		// Pick up the Tasks a previous run of the worker was interrupted in, re-attaching to their containers
+
+		if err := svc.Resume(ctx, errCh); err != nil {
+			level.Error(logger).Log("msg", "failed to resume persisted tasks", "err", err)
+		}
+		level.Info(logger).Log("msg", "worker started", "queue", chConfig.Tasks.Queue)
+
 		// THE GOOD STUFF (svc.Poll)
 
 		for {
 			if err := ctx.Err(); err != nil {
+				// Tasks in process stop their executions as the shutdown policy says and persist their state first.
+				waitCtx, cancelWait := context.WithTimeout(context.Background(), workerShutdownTimeout())
+				if err := svc.Wait(waitCtx); err != nil {
+					level.Error(logger).Log("msg", "shutting down before all tasks were persisted", "err", err)
+				} else {
+					level.Info(logger).Log("msg", "worker stopped")
+				}
+				cancelWait()
+				stopTracing()
 				os.Exit(0)
 			}
+			if !credentialsAvailable(logger, sess) {
+				time.Sleep(time.Second * 10)
+				continue
+			}
 
 			if err := svc.Poll(ctx, errCh); err != nil {
-				if err != nil {
-					time.Sleep(time.Second * 10)
-				}
+				level.Error(logger).Log("msg", "failed to poll the task queue", "err", err)
+				time.Sleep(time.Second * 10)
 			}
 		}
 
 	},
 }
 
-func parseBoolOption(opt string, defaultTo bool) bool {
-	if opt == "" {
-		return defaultTo
+var workerContainersCmd = &cobra.Command{
+	Use:   "containers",
+	Short: "List the containers created for Tasks on the Docker host.",
+	Run: func(_ *cobra.Command, args []string) {
+		list, err := core.ListTaskContainers(context.Background(), getDockerClient())
+		CheckFatal(err)
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "CONTAINER\tTASK\tTEMPLATE\tWORKER\tVERSION\tSTATE\tSTATUS")
+		for _, cntnr := range list {
+			fmt.Fprintf(w, "%.12s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				cntnr.ID,
+				cntnr.Labels[core.LabelTaskHash],
+				cntnr.Labels[core.LabelTemplate],
+				cntnr.Labels[core.LabelWorker],
+				cntnr.Labels[core.LabelVersion],
+				cntnr.State,
+				cntnr.Status,
+			)
+		}
+		CheckFatal(w.Flush())
+	},
+}
+
+// Time the Tasks in process are given to persist their state on shutdown, in addition to the time their containers
+// are given to stop.
+const shutdownMargin = 30 * time.Second
+
+func workerShutdownTimeout() time.Duration {
+	return chConfig.Worker.StopTimeout + shutdownMargin
+}
+
+// File in the worker's workdir the worker ID is kept in.
+const workerIDFilename = ".worker-id"
+
+// Identifies this worker in the labels of its containers. Unless configured, the ID is the hostname the worker first
+// ran with, kept in the workdir: the hostname of a containerised worker changes when it restarts, and a worker that
+// lost its ID would neither resume nor reap the containers it created.
+func workerID(workdir string) (string, error) {
+	if chConfig.Worker.ID != "" {
+		return chConfig.Worker.ID, nil
 	}
 
-	p, err := strconv.ParseBool(opt)
-	CheckFatal(err)
-	return p
+	path := filepath.Join(workdir, workerIDFilename)
+	if b, err := ioutil.ReadFile(path); err == nil && len(strings.TrimSpace(string(b))) > 0 {
+		return strings.TrimSpace(string(b)), nil
+	} else if err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to read the worker ID: %s", err.Error())
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(workdir, 0700); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(path, []byte(hostname+"\n"), 0600); err != nil {
+		return "", fmt.Errorf("failed to record the worker ID: %s", err.Error())
+	}
+	return hostname, nil
 }
 
-func cancelOnSignal(f context.CancelFunc, sigCh <-chan os.Signal) {
+func cancelOnSignal(logger log.Logger, f context.CancelFunc, sigCh <-chan os.Signal) {
 	sig := <-sigCh
-	fmt.Println(fmt.Errorf("Caught signal, cancelling processing.: %s", sig.String()))
-	// level.Info(logger).Log("msg", "Caught signal, cancelling processing.", "signal", sig.String())
+	level.Info(logger).Log("msg", "Caught signal, cancelling processing.", "signal", sig.String())
 	f()
 }
@@ -0,0 +1,52 @@
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+type ChunkMode string
+
+const (
+	// Each chunk Task downloads and processes an equal byte range of the InputResource.
+	ChunkBytes ChunkMode = "bytes"
+	// Each chunk Task downloads the whole InputResource and processes one time segment of it.
+	ChunkTime ChunkMode = "time"
+)
+
+// Identifies the part of its InputResource a chunk Task processes. Chunk Tasks are fanned out from a single Task by
+// the tasker; the Task in their Join is enqueued once every chunk of the Group has completed.
+type Chunk struct {
+	// Shared by every chunk of a fan-out; the hash of the join Task.
+	Group string    `json:"group"`
+	Index int       `json:"index"`
+	Count int       `json:"count"`
+	Mode  ChunkMode `json:"mode"`
+	// Offset and length of the segment in time mode. A zero Duration runs to the end of the input.
+	Start    time.Duration `json:"start,omitempty"`
+	Duration time.Duration `json:"duration,omitempty"`
+}
+
+// Byte range [first, last] of an object of size bytes covered by the chunk in bytes mode.
+func (c *Chunk) ByteRange(size int64) (first int64, last int64) {
+	count := int64(c.Count)
+	first = size * int64(c.Index) / count
+	last = size*int64(c.Index+1)/count - 1
+	return
+}
+
+// Environment variables describing the chunk to the executed code. Start and duration are in whole seconds.
+func (c *Chunk) Env() []string {
+	return []string{
+		"CH_CHUNK_INDEX=" + strconv.Itoa(c.Index),
+		"CH_CHUNK_COUNT=" + strconv.Itoa(c.Count),
+		"CH_CHUNK_MODE=" + string(c.Mode),
+		"CH_CHUNK_START=" + strconv.FormatInt(int64(c.Start/time.Second), 10),
+		"CH_CHUNK_DURATION=" + strconv.FormatInt(int64(c.Duration/time.Second), 10),
+	}
+}
+
+func (c *Chunk) String() string {
+	return fmt.Sprintf("chunk %d/%d (%s) of %s", c.Index+1, c.Count, c.Mode, c.Group)
+}
@@ -0,0 +1,52 @@
+package core
+
+import "testing"
+
+func TestChunkByteRange(t *testing.T) {
+	tests := []struct {
+		name  string
+		index int
+		count int
+		size  int64
+		first int64
+		last  int64
+	}{
+		{name: "single chunk", index: 0, count: 1, size: 100, first: 0, last: 99},
+		{name: "first of even split", index: 0, count: 4, size: 100, first: 0, last: 24},
+		{name: "last of even split", index: 3, count: 4, size: 100, first: 75, last: 99},
+		{name: "first of uneven split", index: 0, count: 3, size: 10, first: 0, last: 2},
+		{name: "middle of uneven split", index: 1, count: 3, size: 10, first: 3, last: 5},
+		{name: "last of uneven split", index: 2, count: 3, size: 10, first: 6, last: 9},
+		{name: "empty chunk of small object", index: 0, count: 4, size: 2, first: 0, last: -1},
+		{name: "empty object", index: 0, count: 1, size: 0, first: 0, last: -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunk := &Chunk{Index: tt.index, Count: tt.count, Mode: ChunkBytes}
+			first, last := chunk.ByteRange(tt.size)
+			if first != tt.first || last != tt.last {
+				t.Errorf("ByteRange(%d) = %d, %d, want %d, %d", tt.size, first, last, tt.first, tt.last)
+			}
+		})
+	}
+}
+
+// The chunks of an object cover every byte exactly once.
+func TestChunkByteRangeCoverage(t *testing.T) {
+	for _, size := range []int64{1, 7, 100, 1<<32 + 3} {
+		for count := 1; count <= 9; count++ {
+			next := int64(0)
+			for index := 0; index < count; index++ {
+				first, last := (&Chunk{Index: index, Count: count, Mode: ChunkBytes}).ByteRange(size)
+				if first != next {
+					t.Fatalf("size %d, chunk %d/%d starts at %d, want %d", size, index, count, first, next)
+				}
+				next = last + 1
+			}
+			if next != size {
+				t.Fatalf("size %d, %d chunks end at %d, want %d", size, count, next-1, size-1)
+			}
+		}
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strconv"
 
+	"github.com/hashicorp/go-multierror"
 	"kroekerlabs.dev/chyme/services/pkg/hash"
 )
 
@@ -23,18 +25,28 @@ type ExecutionResult struct {
 
 // Identifies and configures the Executor to be used for a Task.
 type ExecutionStrategy struct {
-	Executor string            `json:"name"`
-	Config   map[string]string `json:"config"`
-	hash     string
+	Executor string `json:"name"`
+	// Version of the Config schema, ExecutorConfigVersion for every ExecutionStrategy created or decoded.
+	Version int             `json:"version"`
+	Config  *ExecutorConfig `json:"config"`
+	hash    string
 }
 
+// Creates an ExecutionStrategy of the current config version.
+func NewExecutionStrategy(executor string, config *ExecutorConfig) *ExecutionStrategy {
+	return &ExecutionStrategy{Executor: executor, Version: ExecutorConfigVersion, Config: config}
+}
+
+// Hashes the ExecutionStrategy. The config is JSON encoded first, which orders map keys, so equal configs hash equally.
 func (s *ExecutionStrategy) Hash() string {
 	if s.hash == "" {
+		config, _ := json.Marshal(s.Config)
 		hasher := hash.NewStruct()
 		_ = hasher.Encode(struct {
 			Executor string
-			Config   [][2]string
-		}{s.Executor, mapToSortedTuples(s.Config)})
+			Version  int
+			Config   string
+		}{s.Executor, s.Version, string(config)})
 		s.hash = hasher.Hash()
 	}
 	return s.hash
@@ -43,9 +55,9 @@ func (s *ExecutionStrategy) Hash() string {
 func (s *ExecutionStrategy) String() string {
 	config, err := json.MarshalIndent(s.Config, "  ", "  ")
 	if err != nil {
-		config = []byte("(failed to marshal config map)")
+		config = []byte("(failed to marshal config)")
 	}
-	return "Executor: " + s.Executor + "\nConfig:\n  " + string(config)
+	return "Executor: " + s.Executor + "\nVersion: " + strconv.Itoa(s.Version) + "\nConfig:\n  " + string(config)
 }
 
 type ExecutorRegistry map[string]TaskExecutor
@@ -77,4 +89,61 @@ func (e *taskExecutor) Clean(task *Task) error {
 		return fmt.Errorf("unknown executor %s", task.ExecutionStrategy.Executor)
 	}
 	return executor.Clean(task)
-}
\ No newline at end of file
+}
+
+// An execution of a Task that lives outside the worker process, e.g. a Docker container. It can outlive the worker
+// that started it.
+type Execution struct {
+	ID       string
+	TaskHash string
+	State    string
+	Executor string
+}
+
+// Implemented by TaskExecutors whose executions outlive the worker, so a restarted worker can find the executions it
+// started and remove those it has no Task for.
+type ExecutionLister interface {
+	Executions(ctx context.Context) ([]*Execution, error)
+	Reap(ctx context.Context, execution *Execution) error
+}
+
+// Lists the executions of every registered executor that implements ExecutionLister. Executors that fail to list
+// theirs, e.g. a container runtime that isn't running, don't hide those of the others.
+func (e *taskExecutor) Executions(ctx context.Context) ([]*Execution, error) {
+	executions := make([]*Execution, 0)
+	errs := &multierror.Error{}
+	for name, executor := range e.registry {
+		lister, ok := executor.(ExecutionLister)
+		if !ok {
+			continue
+		}
+		list, err := lister.Executions(ctx)
+		if err != nil {
+			errs = multierror.Append(errs, fmt.Errorf("failed to list executions of executor %s: %s", name, err.Error()))
+			continue
+		}
+		executions = append(executions, list...)
+	}
+	return executions, errs.ErrorOrNil()
+}
+
+// Sweeps the secret files of every registered executor that implements SecretSweeper.
+func (e *taskExecutor) SweepSecrets(keep map[string]bool) error {
+	errs := &multierror.Error{}
+	for name, executor := range e.registry {
+		if sweeper, ok := executor.(SecretSweeper); ok {
+			if err := sweeper.SweepSecrets(keep); err != nil {
+				errs = multierror.Append(errs, fmt.Errorf("failed to sweep secrets of executor %s: %s", name, err.Error()))
+			}
+		}
+	}
+	return errs.ErrorOrNil()
+}
+
+func (e *taskExecutor) Reap(ctx context.Context, execution *Execution) error {
+	lister, ok := e.registry[execution.Executor].(ExecutionLister)
+	if !ok {
+		return fmt.Errorf("executor %s cannot reap executions", execution.Executor)
+	}
+	return lister.Reap(ctx, execution)
+}
@@ -0,0 +1,357 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/docker/go-units"
+	"github.com/hashicorp/go-multierror"
+)
+
+// Names of the executors.
+const (
+	ExecutorDocker  = "docker"
+	ExecutorPodman  = "podman"
+	ExecutorOCI     = "oci"
+	ExecutorProcess = "process"
+	ExecutorInmem   = "inmem"
+)
+
+// Version of the ExecutorConfig schema. Version 0 is the flat string map ExecutionStrategies were first written with,
+// which is still read so that Tasks queued before the schema existed can be executed.
+const ExecutorConfigVersion = 1
+
+// Configures the executor of an ExecutionStrategy. Which fields apply depends on the executor:
+//
+//	docker, podman, oci: Image, Command, Args, Workdir, Env, Secrets, Mounts, Network, Scratch, Limits
+//	process:             Command, Args, Workdir, Env
+//	inmem:               Executable
+type ExecutorConfig struct {
+	Image string `json:"image,omitempty" yaml:"image"`
+	// Entrypoint of a container, or the executable and its first arguments for the process executor.
+	Command []string          `json:"command,omitempty" yaml:"command"`
+	Args    []string          `json:"args,omitempty" yaml:"args"`
+	Workdir string            `json:"workdir,omitempty" yaml:"workdir"`
+	Env     map[string]string `json:"env,omitempty" yaml:"env"`
+	// Vault secrets delivered to a container as environment variables or files.
+	Secrets []Secret `json:"secrets,omitempty" yaml:"secrets"`
+	// Host paths mounted read-only into a container.
+	Mounts []Mount `json:"mounts,omitempty" yaml:"mounts"`
+	// Network mode of a container, DefaultNetworkMode unless set.
+	Network string `json:"network,omitempty" yaml:"network"`
+	// Size of a tmpfs mounted at ScratchDir; none if 0.
+	Scratch ByteSize      `json:"scratch,omitempty" yaml:"scratch"`
+	Limits  *LimitsConfig `json:"limits,omitempty" yaml:"limits"`
+	// Name of the InmemExecutable run by the inmem executor.
+	Executable string `json:"executable,omitempty" yaml:"executable"`
+}
+
+type Mount struct {
+	Host      string `json:"host" yaml:"host"`
+	Container string `json:"container" yaml:"container"`
+}
+
+// Resource limits of a container. Zero values are unset.
+type LimitsConfig struct {
+	CPUs float64 `json:"cpus,omitempty" yaml:"cpus,omitempty"`
+	// Bytes. MemorySwap may be -1 to allow unlimited swap.
+	Memory     ByteSize          `json:"memory,omitempty" yaml:"memory,omitempty"`
+	MemorySwap ByteSize          `json:"memorySwap,omitempty" yaml:"memorySwap,omitempty"`
+	PidsLimit  int64             `json:"pidsLimit,omitempty" yaml:"pidsLimit,omitempty"`
+	ShmSize    ByteSize          `json:"shmSize,omitempty" yaml:"shmSize,omitempty"`
+	Ulimits    map[string]Ulimit `json:"ulimits,omitempty" yaml:"ulimits,omitempty"`
+}
+
+func (c *LimitsConfig) ResourceLimits() *ResourceLimits {
+	if c == nil {
+		return &ResourceLimits{}
+	}
+	return &ResourceLimits{
+		NanoCPUs:   int64(c.CPUs * 1e9),
+		Memory:     int64(c.Memory),
+		MemorySwap: int64(c.MemorySwap),
+		PidsLimit:  c.PidsLimit,
+		ShmSize:    int64(c.ShmSize),
+		Ulimits:    c.Ulimits,
+	}
+}
+
+// A number of bytes. It is encoded as a number, and decodes from a number or a string with a unit, e.g. 512m or 4g.
+type ByteSize int64
+
+func (b *ByteSize) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	return b.set(v)
+}
+
+func (b *ByteSize) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var v interface{}
+	if err := unmarshal(&v); err != nil {
+		return err
+	}
+	return b.set(v)
+}
+
+func (b *ByteSize) set(v interface{}) error {
+	switch v := v.(type) {
+	case float64:
+		*b = ByteSize(v)
+	case int:
+		*b = ByteSize(v)
+	case int64:
+		*b = ByteSize(v)
+	case uint64:
+		*b = ByteSize(v)
+	case string:
+		if v == "-1" {
+			*b = -1
+			return nil
+		}
+		size, err := units.RAMInBytes(v)
+		if err != nil {
+			return fmt.Errorf("invalid size %q", v)
+		}
+		*b = ByteSize(size)
+	case nil:
+		*b = 0
+	default:
+		return fmt.Errorf("invalid size %v", v)
+	}
+	return nil
+}
+
+// Checks that the ExecutionStrategy names a known executor and configures it completely, so that a malformed
+// Template fails when it is loaded rather than when its Tasks execute.
+func (s *ExecutionStrategy) Validate() error {
+	if s.Version > ExecutorConfigVersion {
+		return fmt.Errorf("unsupported executor config version %d", s.Version)
+	}
+	c := s.Config
+	if c == nil {
+		c = &ExecutorConfig{}
+	}
+
+	errs := &multierror.Error{}
+	switch s.Executor {
+	case ExecutorDocker, ExecutorPodman, ExecutorOCI:
+		if c.Image == "" {
+			errs = multierror.Append(errs, errors.New("no image specified"))
+		}
+		for _, m := range c.Mounts {
+			errs = multierror.Append(errs, m.Validate())
+		}
+		for _, secret := range c.Secrets {
+			errs = multierror.Append(errs, secret.Validate())
+		}
+		if c.Scratch < 0 {
+			errs = multierror.Append(errs, errors.New("scratch size is negative"))
+		}
+		if c.Workdir != "" && !path.IsAbs(c.Workdir) {
+			errs = multierror.Append(errs, fmt.Errorf("workdir %s is not absolute", c.Workdir))
+		}
+		errs = multierror.Append(errs, c.Limits.Validate())
+		errs = multierror.Append(errs, unsupported(s.Executor, "executable", c.Executable != ""))
+	case ExecutorProcess:
+		if len(c.Command) == 0 {
+			errs = multierror.Append(errs, errors.New("no command specified"))
+		}
+		errs = multierror.Append(errs, unsupported(s.Executor, "image", c.Image != ""))
+		errs = multierror.Append(errs, unsupported(s.Executor, "secrets", len(c.Secrets) > 0))
+		errs = multierror.Append(errs, unsupported(s.Executor, "mounts", len(c.Mounts) > 0))
+		errs = multierror.Append(errs, unsupported(s.Executor, "network", c.Network != ""))
+		errs = multierror.Append(errs, unsupported(s.Executor, "scratch", c.Scratch != 0))
+		errs = multierror.Append(errs, unsupported(s.Executor, "limits", c.Limits != nil))
+		errs = multierror.Append(errs, unsupported(s.Executor, "executable", c.Executable != ""))
+	case ExecutorInmem:
+		if c.Executable == "" {
+			errs = multierror.Append(errs, errors.New("no executable specified"))
+		}
+		errs = multierror.Append(errs, unsupported(s.Executor, "image", c.Image != ""))
+		errs = multierror.Append(errs, unsupported(s.Executor, "command", len(c.Command) > 0))
+		errs = multierror.Append(errs, unsupported(s.Executor, "args", len(c.Args) > 0))
+		errs = multierror.Append(errs, unsupported(s.Executor, "workdir", c.Workdir != ""))
+		errs = multierror.Append(errs, unsupported(s.Executor, "env", len(c.Env) > 0))
+		errs = multierror.Append(errs, unsupported(s.Executor, "secrets", len(c.Secrets) > 0))
+		errs = multierror.Append(errs, unsupported(s.Executor, "mounts", len(c.Mounts) > 0))
+		errs = multierror.Append(errs, unsupported(s.Executor, "network", c.Network != ""))
+		errs = multierror.Append(errs, unsupported(s.Executor, "scratch", c.Scratch != 0))
+		errs = multierror.Append(errs, unsupported(s.Executor, "limits", c.Limits != nil))
+	case "":
+		return errors.New("no executor specified")
+	default:
+		return fmt.Errorf("unknown executor %s", s.Executor)
+	}
+	if err := errs.ErrorOrNil(); err != nil {
+		return fmt.Errorf("invalid %s executor config: %s", s.Executor, err.Error())
+	}
+	return nil
+}
+
+func unsupported(executor string, field string, set bool) error {
+	if set {
+		return fmt.Errorf("%s is not supported by the %s executor", field, executor)
+	}
+	return nil
+}
+
+// Checks the mount's paths. The host path is checked against the worker's mount roots when the container is created.
+func (m Mount) Validate() error {
+	host, target := path.Clean(m.Host), path.Clean(m.Container)
+	if !path.IsAbs(host) || !path.IsAbs(target) {
+		return fmt.Errorf("mount %s:%s: paths must be absolute", m.Host, m.Container)
+	}
+	for _, reserved := range []string{InputMountPath, OutputMountPath, ScratchDir, SecretsMountPath} {
+		if target == reserved || strings.HasPrefix(target, reserved+"/") {
+			return fmt.Errorf("mount %s:%s: %s is reserved", m.Host, m.Container, reserved)
+		}
+	}
+	return nil
+}
+
+func (c *LimitsConfig) Validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.CPUs < 0 || c.Memory < 0 || c.MemorySwap < -1 || c.PidsLimit < 0 || c.ShmSize < 0 {
+		return errors.New("limits must not be negative")
+	}
+	for name, u := range c.Ulimits {
+		if u.Soft > u.Hard {
+			return fmt.Errorf("ulimit %s: soft limit %d exceeds hard limit %d", name, u.Soft, u.Hard)
+		}
+	}
+	return nil
+}
+
+// Environment as KEY=value pairs sorted by key.
+func (c *ExecutorConfig) EnvSlice() []string {
+	keys := make([]string, 0, len(c.Env))
+	for k := range c.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	env := make([]string, len(keys))
+	for i, k := range keys {
+		env[i] = k + "=" + c.Env[k]
+	}
+	return env
+}
+
+// Keys of the version 0 config map.
+const (
+	legacyImage      = "image"
+	legacyEnv        = "env"    // newline-separated KEY=value pairs
+	legacyMounts     = "mounts" // newline-separated host:container paths
+	legacyNetwork    = "network"
+	legacyCommand    = "command" // newline-separated
+	legacyArgs       = "args"    // newline-separated
+	legacyWorkdir    = "workdir"
+	legacyScratch    = "scratch" // size with a unit, e.g. 1g
+	legacyExecutable = "executable"
+)
+
+// Converts a version 0 config map. Its limit keys are those of ParseResourceLimits.
+func LegacyExecutorConfig(config map[string]string) (*ExecutorConfig, error) {
+	c := &ExecutorConfig{
+		Image:      config[legacyImage],
+		Command:    splitLines(config[legacyCommand]),
+		Args:       splitLines(config[legacyArgs]),
+		Workdir:    config[legacyWorkdir],
+		Network:    config[legacyNetwork],
+		Executable: config[legacyExecutable],
+	}
+
+	if env := splitLines(config[legacyEnv]); len(env) > 0 {
+		c.Env = make(map[string]string, len(env))
+		for _, kv := range env {
+			if i := strings.IndexRune(kv, '='); i > 0 {
+				c.Env[kv[:i]] = kv[i+1:]
+			}
+		}
+	}
+
+	for _, mount := range splitLines(config[legacyMounts]) {
+		parts := strings.Split(strings.TrimSuffix(mount, ":ro"), ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("mount %s is not host:container", mount)
+		}
+		c.Mounts = append(c.Mounts, Mount{Host: parts[0], Container: parts[1]})
+	}
+
+	if scratch := config[legacyScratch]; scratch != "" {
+		if err := c.Scratch.set(scratch); err != nil {
+			return nil, err
+		}
+	}
+
+	limits, err := ParseResourceLimits(config)
+	if err != nil {
+		return nil, err
+	}
+	if !limits.isZero() {
+		c.Limits = newLimitsConfig(limits)
+	}
+	return c, nil
+}
+
+// Parses a LimitsConfig of the key=value;key=value form of ParseResourceLimitsString, which workers are configured
+// with in the environment.
+func ParseLimitsConfig(s string) (*LimitsConfig, error) {
+	limits, err := ParseResourceLimitsString(s)
+	if err != nil {
+		return nil, err
+	}
+	return newLimitsConfig(limits), nil
+}
+
+func newLimitsConfig(limits *ResourceLimits) *LimitsConfig {
+	return &LimitsConfig{
+		CPUs:       float64(limits.NanoCPUs) / 1e9,
+		Memory:     ByteSize(limits.Memory),
+		MemorySwap: ByteSize(limits.MemorySwap),
+		PidsLimit:  limits.PidsLimit,
+		ShmSize:    ByteSize(limits.ShmSize),
+		Ulimits:    limits.Ulimits,
+	}
+}
+
+// Decodes an ExecutionStrategy of any config version, converting version 0 configs.
+func (s *ExecutionStrategy) UnmarshalJSON(data []byte) error {
+	var aux struct {
+		Executor string          `json:"name"`
+		Version  int             `json:"version"`
+		Config   json.RawMessage `json:"config"`
+	}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	s.Executor, s.Version, s.Config = aux.Executor, ExecutorConfigVersion, &ExecutorConfig{}
+
+	switch {
+	case len(aux.Config) == 0 || string(aux.Config) == "null":
+		return nil
+	case aux.Version == 0:
+		var legacy map[string]string
+		if err := json.Unmarshal(aux.Config, &legacy); err != nil {
+			return fmt.Errorf("invalid version 0 executor config: %s", err.Error())
+		}
+		config, err := LegacyExecutorConfig(legacy)
+		if err != nil {
+			return fmt.Errorf("invalid version 0 executor config: %s", err.Error())
+		}
+		s.Config = config
+		return nil
+	case aux.Version > ExecutorConfigVersion:
+		return fmt.Errorf("unsupported executor config version %d", aux.Version)
+	default:
+		return json.Unmarshal(aux.Config, s.Config)
+	}
+}
@@ -0,0 +1,183 @@
+package core
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestByteSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    ByteSize
+		wantErr bool
+	}{
+		{name: "number", value: `1024`, want: 1024},
+		{name: "number string", value: `"1024"`, want: 1024},
+		{name: "kilobytes", value: `"4k"`, want: 4 << 10},
+		{name: "megabytes", value: `"512m"`, want: 512 << 20},
+		{name: "gigabytes upper case", value: `"4G"`, want: 4 << 30},
+		{name: "unlimited", value: `"-1"`, want: -1},
+		{name: "null", value: `null`, want: 0},
+		{name: "unknown unit", value: `"4x"`, wantErr: true},
+		{name: "not a size", value: `"big"`, wantErr: true},
+		{name: "bool", value: `true`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// JSON values are also valid YAML, so both decoders are checked with the same input.
+			for decoder, unmarshal := range map[string]func([]byte, interface{}) error{
+				"json": json.Unmarshal,
+				"yaml": yaml.Unmarshal,
+			} {
+				b := ByteSize(7)
+				err := unmarshal([]byte(tt.value), &b)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("%s: error = %v, want error %t", decoder, err, tt.wantErr)
+				}
+				if err == nil && b != tt.want {
+					t.Errorf("%s: decoded %d, want %d", decoder, b, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestLegacyExecutorConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  map[string]string
+		want    *ExecutorConfig
+		wantErr bool
+	}{
+		{
+			name:   "image only",
+			config: map[string]string{"image": "mov_converter:0.1.4"},
+			want:   &ExecutorConfig{Image: "mov_converter:0.1.4", Command: []string{}, Args: []string{}},
+		},
+		{
+			name: "every key",
+			config: map[string]string{
+				"image":       "mov_converter",
+				"command":     "/usr/local/bin/convert\n",
+				"args":        "--preset\n\nslow",
+				"workdir":     "/scratch",
+				"network":     "bridge",
+				"env":         "PRESET=slow\nMALFORMED\nEMPTY=\nURL=a=b",
+				"mounts":      "/srv/luts:/luts:ro\n/srv/models:/models",
+				"scratch":     "2g",
+				"cpus":        "1.5",
+				"memory":      "4g",
+				"memory_swap": "-1",
+				"pids_limit":  "512",
+				"shm_size":    "256m",
+				"ulimits":     "nofile=1024:2048",
+			},
+			want: &ExecutorConfig{
+				Image:   "mov_converter",
+				Command: []string{"/usr/local/bin/convert"},
+				Args:    []string{"--preset", "slow"},
+				Workdir: "/scratch",
+				Network: "bridge",
+				Env:     map[string]string{"PRESET": "slow", "EMPTY": "", "URL": "a=b"},
+				Mounts:  []Mount{{Host: "/srv/luts", Container: "/luts"}, {Host: "/srv/models", Container: "/models"}},
+				Scratch: 2 << 30,
+				Limits: &LimitsConfig{
+					CPUs:       1.5,
+					Memory:     4 << 30,
+					MemorySwap: -1,
+					PidsLimit:  512,
+					ShmSize:    256 << 20,
+					Ulimits:    map[string]Ulimit{"nofile": {Soft: 1024, Hard: 2048}},
+				},
+			},
+		},
+		{
+			name:   "executable",
+			config: map[string]string{"executable": "manifest"},
+			want:   &ExecutorConfig{Command: []string{}, Args: []string{}, Executable: "manifest"},
+		},
+		{name: "mount without container path", config: map[string]string{"mounts": "/srv/luts"}, wantErr: true},
+		{name: "invalid scratch", config: map[string]string{"scratch": "lots"}, wantErr: true},
+		{name: "invalid limit", config: map[string]string{"cpus": "two"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LegacyExecutorConfig(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %t", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LegacyExecutorConfig() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecutionStrategyUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    *ExecutionStrategy
+		wantErr bool
+	}{
+		{
+			name: "version 0 map",
+			data: `{"name":"docker","config":{"image":"mov_converter","memory":"512m"}}`,
+			want: &ExecutionStrategy{Executor: ExecutorDocker, Version: ExecutorConfigVersion, Config: &ExecutorConfig{
+				Image:   "mov_converter",
+				Command: []string{},
+				Args:    []string{},
+				Limits:  &LimitsConfig{Memory: 512 << 20},
+			}},
+		},
+		{
+			name: "explicit version 0",
+			data: `{"name":"inmem","version":0,"config":{"executable":"manifest"}}`,
+			want: &ExecutionStrategy{Executor: ExecutorInmem, Version: ExecutorConfigVersion, Config: &ExecutorConfig{
+				Command:    []string{},
+				Args:       []string{},
+				Executable: "manifest",
+			}},
+		},
+		{
+			name: "version 1",
+			data: `{"name":"docker","version":1,"config":{"image":"mov_converter","scratch":"1g"}}`,
+			want: &ExecutionStrategy{Executor: ExecutorDocker, Version: ExecutorConfigVersion, Config: &ExecutorConfig{
+				Image:   "mov_converter",
+				Scratch: 1 << 30,
+			}},
+		},
+		{
+			name: "no config",
+			data: `{"name":"docker"}`,
+			want: &ExecutionStrategy{Executor: ExecutorDocker, Version: ExecutorConfigVersion, Config: &ExecutorConfig{}},
+		},
+		{
+			name: "null config",
+			data: `{"name":"docker","config":null}`,
+			want: &ExecutionStrategy{Executor: ExecutorDocker, Version: ExecutorConfigVersion, Config: &ExecutorConfig{}},
+		},
+		{name: "version 0 with nested value", data: `{"name":"docker","config":{"limits":{"cpus":2}}}`, wantErr: true},
+		{name: "version 0 with invalid limit", data: `{"name":"docker","config":{"cpus":"two"}}`, wantErr: true},
+		{name: "future version", data: `{"name":"docker","version":2,"config":{}}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := &ExecutionStrategy{}
+			err := json.Unmarshal([]byte(tt.data), got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %t", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decoded %+v (config %+v), want %+v (config %+v)", got, got.Config, tt.want, tt.want.Config)
+			}
+		})
+	}
+}
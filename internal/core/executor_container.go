@@ -0,0 +1,106 @@
+package core
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Container paths of the Task's workspace and scratch space.
+const (
+	InputMountPath  = "/in"
+	OutputMountPath = "/out"
+	ScratchDir      = "/scratch"
+)
+
+// Network mode of containers whose ExecutionStrategy sets none. Images are not trusted with network access unless
+// their template asks for it.
+const DefaultNetworkMode = "none"
+
+// How a Task's container is run, parsed from its ExecutionStrategy config.
+type containerSpec struct {
+	Binds      []string
+	Network    string
+	Entrypoint []string
+	Cmd        []string
+	WorkingDir string
+	// Size in bytes of the tmpfs mounted at ScratchDir; none if 0.
+	ScratchSize int64
+}
+
+// Resolves the container spec of a Task. Files added to the Task's input directory (~/chyme/<task hash>/input) are
+// placed on the container at /in, and files added to /out on the container end up in the Task's output directory
+// (~/chyme/<task hash>/output). Host paths of extra mounts must be under one of mountRoots; without any, Tasks may
+// mount nothing, as a template or queue message could otherwise mount e.g. the Docker socket.
+func parseContainerSpec(task *Task, mountRoots []string) (*containerSpec, error) {
+	config := task.ExecutionStrategy.Config
+	spec := &containerSpec{
+		Binds: []string{
+			"/" + task.Workspace.InputDir + ":" + InputMountPath,
+			"/" + task.Workspace.OutputDir + ":" + OutputMountPath,
+		},
+		Network:     DefaultNetworkMode,
+		Entrypoint:  config.Command,
+		Cmd:         config.Args,
+		WorkingDir:  config.Workdir,
+		ScratchSize: int64(config.Scratch),
+	}
+	if config.Network != "" {
+		spec.Network = config.Network
+	}
+
+	for _, mount := range config.Mounts {
+		host := filepath.Clean(mount.Host)
+		if len(mountRoots) == 0 {
+			return nil, fmt.Errorf("invalid configuration: mount %s: this worker has no mount roots configured", mount.Host)
+		}
+		if !underAny(host, mountRoots) {
+			return nil, fmt.Errorf("invalid configuration: mount %s: host path is not under %s", mount.Host, strings.Join(mountRoots, ", "))
+		}
+		spec.Binds = append(spec.Binds, host+":"+filepath.Clean(mount.Container)+":ro")
+	}
+	return spec, nil
+}
+
+func underAny(p string, roots []string) bool {
+	for _, root := range roots {
+		root = filepath.Clean(root)
+		if p == root || strings.HasPrefix(p, root+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Options of the scratch tmpfs.
+func (s *containerSpec) scratchOptions() string {
+	return "rw,size=" + strconv.FormatInt(s.ScratchSize, 10)
+}
+
+// Environment of a Task's container: the env of the ExecutionStrategy config and the Task's chunk.
+func containerEnv(task *Task) []string {
+	env := task.ExecutionStrategy.Config.EnvSlice()
+	if task.Chunk != nil {
+		env = append(env, task.Chunk.Env()...)
+	}
+	return env
+}
+
+func containerLabels(task *Task, workerID string) map[string]string {
+	return map[string]string{
+		LabelTaskHash: task.Hash(),
+		LabelTemplate: task.Template,
+		LabelWorker:   workerID,
+		LabelVersion:  task.Version,
+	}
+}
+
+// Limits of a Task's container: those of its ExecutionStrategy config, resolved against the executor's.
+func containerLimits(task *Task, defaults *ResourceLimits, max *ResourceLimits) (*ResourceLimits, error) {
+	limits, err := task.ExecutionStrategy.Config.Limits.ResourceLimits().Resolve(defaults, max)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %s", err.Error())
+	}
+	return limits, nil
+}
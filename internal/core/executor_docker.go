@@ -4,32 +4,98 @@ import (
 	"context"
 	"errors"
 	"fmt"
-
-	// "io"
-	// "os"
-	// "path/filepath"
-	"strings"
 	"time"
 
 	"docker.io/go-docker"
 	"docker.io/go-docker/api/types"
 	"docker.io/go-docker/api/types/container"
+	"docker.io/go-docker/api/types/filters"
+	"docker.io/go-docker/api/types/strslice"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/hashicorp/go-multierror"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"kroekerlabs.dev/chyme/services/pkg/tracing"
+)
+
+// Labels set on the containers created for Tasks.
+const (
+	LabelTaskHash = "chyme.task.hash"
+	LabelTemplate = "chyme.template"
+	LabelWorker   = "chyme.worker"
+	LabelVersion  = "chyme.version"
+)
+
+// What the Docker executor does with the containers in process when the worker shuts down.
+type ShutdownPolicy string
+
+const (
+	// Leave containers running, for the restarted worker to reattach to.
+	ShutdownLeave ShutdownPolicy = "leave"
+	// Stop and remove containers; the restarted worker runs their Tasks again in new containers.
+	ShutdownStop ShutdownPolicy = "stop"
 )
 
+// Time a container is given to exit after SIGTERM before it is sent SIGKILL, unless configured otherwise.
+const DefaultStopGracePeriod = 10 * time.Second
+
+func ParseShutdownPolicy(policy string) (ShutdownPolicy, error) {
+	switch p := ShutdownPolicy(policy); p {
+	case "":
+		return ShutdownLeave, nil
+	case ShutdownLeave, ShutdownStop:
+		return p, nil
+	default:
+		return "", fmt.Errorf("invalid shutdown policy %s: must be %s or %s", policy, ShutdownLeave, ShutdownStop)
+	}
+}
+
+// Configures the Docker-backed TaskExecutor.
+type DockerExecutorConfig struct {
+	// Name the executor is selected by in ExecutionStrategies, docker unless set. A Podman API socket serves the
+	// Docker API, so the same executor runs Tasks on Podman under another name.
+	Name string
+	// Identifies the worker in the labels of the containers it creates.
+	WorkerID string
+	// User that runs the commands inside the container: this user needs to exist on the container.
+	User         string
+	PullPolicy   PullPolicy
+	ShouldRemove bool
+	// Credentials images are pulled with; nil pulls anonymously.
+	RegistryAuth RegistryAuth
+	// Resolves the secrets of ExecutionStrategies; Tasks with secrets fail if nil. Secret files are written under
+	// SecretsDir, DefaultSecretsDir unless set.
+	Secrets    SecretResolver
+	SecretsDir string
+	// Limits applied to containers whose ExecutionStrategy sets none, and the most any ExecutionStrategy may set.
+	DefaultLimits *ResourceLimits
+	MaxLimits     *ResourceLimits
+	// Host directories that extra mounts of ExecutionStrategies must be under; no extra mounts are allowed if empty.
+	MountRoots []string
+	// Time a stopped container is given to exit after SIGTERM before it is sent SIGKILL.
+	StopGracePeriod time.Duration
+	ShutdownPolicy  ShutdownPolicy
+	// Logger of the executor, which discards the log if nil.
+	Logger log.Logger
+}
+
 // Docker-backed TaskExecutor.
 type dockerTaskExecutor struct {
-	client       *docker.Client
-	user         string
-	shouldPull   bool
-	shouldRemove bool
+	*DockerExecutorConfig
+	client *docker.Client
 }
 
-func NewDockerTaskExecutor(cli *docker.Client, user string, shouldPull bool, shouldRemove bool) TaskExecutor {
-	return &dockerTaskExecutor{cli, user, shouldPull, shouldRemove}
+func NewDockerTaskExecutor(cli *docker.Client, config *DockerExecutorConfig) TaskExecutor {
+	config.Logger = loggerOrNop(config.Logger)
+	return &dockerTaskExecutor{config, cli}
 }
 
 func (e *dockerTaskExecutor) Name() string {
-	return "docker"
+	if e.DockerExecutorConfig.Name != "" {
+		return e.DockerExecutorConfig.Name
+	}
+	return ExecutorDocker
 }
 
 // Processes a task using a Docker container.
@@ -37,10 +103,11 @@ func (e *dockerTaskExecutor) Execute(ctx context.Context, task *Task) (*Executio
 	if task.ExecutionStrategy.Executor != e.Name() {
 		return nil, fmt.Errorf("invalid executor (%s) should be %s", task.ExecutionStrategy.Executor, e.Name())
 	}
-	image := task.ExecutionStrategy.Config["image"]
-	if image == "" {
-		return nil, errors.New("invalid configuration: no image specified")
+	if err := task.ExecutionStrategy.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %s", err.Error())
 	}
+	image := task.ExecutionStrategy.Config.Image
+	logger := log.With(e.Logger, "executor", e.Name(), "task", task.Hash(), "template", task.Template)
 
 	var (
 		timeoutTimer *time.Timer
@@ -59,13 +126,18 @@ func (e *dockerTaskExecutor) Execute(ctx context.Context, task *Task) (*Executio
 	}
 
 	if containerID == "" {
-		if e.shouldPull {
-			if err := e.pullImage(localCtx, image); err != nil {
-				return nil, err
-			}
+		if err := e.ensureImage(localCtx, image); err != nil {
+			return nil, err
+		}
+		// The container is created from the digest, so the Task records exactly what ran even if the tag moves.
+		digest, err := e.resolveImage(localCtx, image)
+		if err != nil {
+			return nil, err
 		}
+		task.ImageDigest = digest
+		level.Debug(logger).Log("msg", "image resolved", "image", image, "digest", digest)
 
-		resp, err := e.makeContainer(localCtx, image, task)
+		resp, err := e.makeContainer(localCtx, digest, task)
 
 		if err != nil {
 			return nil, err
@@ -74,46 +146,75 @@ func (e *dockerTaskExecutor) Execute(ctx context.Context, task *Task) (*Executio
 			return nil, err
 		}
 		containerID = resp.ID
+		level.Debug(logger).Log("msg", "container started", "container", containerID)
+	} else if err := e.startCreated(localCtx, task, containerID); err != nil {
+		// A worker that stopped between creating and starting the container left it never run.
+		return nil, err
 	}
 
+	_, span := tracing.Tracer().Start(ctx, "container.run", trace.WithAttributes(
+		attribute.String("container.id", containerID),
+		attribute.String("container.image", task.ImageDigest),
+	))
 	var execErr error
+	// Whether the container is left running for a restarted worker, as of ShutdownLeave.
+	var leftRunning bool
 	statusCh, errCh := e.client.ContainerWait(localCtx, containerID, container.WaitConditionNotRunning)
 	select {
 	case <-timeoutChan:
-		fmt.Println("hit timeout in executor_docker")
-		if killErr := e.killContainer(ctx, containerID, "SIGKILL"); killErr != nil {
-			err = fmt.Errorf("exceeded timeout (%s) and failed to kill container: %s", task.Timeout.String(), killErr.Error())
+		level.Warn(logger).Log("msg", "timeout exceeded, stopping container", "container", containerID,
+			"timeout", task.Timeout)
+		if stopErr := e.stopContainer(localCtx, containerID); stopErr != nil {
+			err = fmt.Errorf("exceeded timeout (%s) and failed to stop container: %s", task.Timeout.String(), stopErr.Error())
 		} else {
-			err = fmt.Errorf("exceeded timeout (%s), container killed", task.Timeout.String())
+			// The container's logs are still collected, so a timeout is reported as an error of the execution.
+			execErr = fmt.Errorf("exceeded timeout (%s), container stopped", task.Timeout.String())
 		}
 	case <-ctx.Done():
-		fmt.Println("hit ctx.Done in executor_docker")
+		level.Debug(logger).Log("msg", "processing cancelled", "container", containerID, "policy", e.ShutdownPolicy)
 		err = ctx.Err()
+		leftRunning = e.ShutdownPolicy != ShutdownStop
+		if e.ShutdownPolicy == ShutdownStop {
+			// Removing the container makes the resumed Task start over in a new one.
+			if stopErr := e.stopContainer(localCtx, containerID); stopErr != nil {
+				level.Error(logger).Log("msg", "failed to stop container on shutdown", "container", containerID,
+					"err", stopErr)
+			} else if rmErr := e.client.ContainerRemove(localCtx, containerID, types.ContainerRemoveOptions{}); rmErr != nil {
+				level.Error(logger).Log("msg", "failed to remove container on shutdown", "container", containerID,
+					"err", rmErr)
+			}
+		}
 	case e := <-errCh:
-		fmt.Println("hit errCh in executor_docker")
-		fmt.Println(e.Error())
 		err = e
 	case status := <-statusCh:
-		fmt.Println("hit statusCh in executor_docker")
-		fmt.Println(status.StatusCode)
+		level.Debug(logger).Log("msg", "container exited", "container", containerID, "code", status.StatusCode)
 		if status.StatusCode != 0 {
-			execErr = fmt.Errorf("container returned non-zero status %d", status.StatusCode)
+			execErr = &ExitError{Code: int(status.StatusCode)}
 		}
 	}
 
 	if timeoutTimer != nil {
 		timeoutTimer.Stop()
 	}
+	tracing.End(span, multierror.Append(err, execErr).ErrorOrNil())
 
+	// The secret files are only needed by a container left running for the resumed Task to reattach to.
+	if !leftRunning {
+		if rmErr := removeSecrets(task, e.SecretsDir); rmErr != nil {
+			level.Error(logger).Log("msg", "failed to remove secret files", "err", rmErr)
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
-
 	return e.makeResult(task, execErr)
 }
 
 func (e *dockerTaskExecutor) Clean(task *Task) error {
-	if !e.shouldRemove {
+	if err := removeSecrets(task, e.SecretsDir); err != nil {
+		return err
+	}
+	if !e.ShouldRemove {
 		return nil
 	}
 
@@ -121,12 +222,66 @@ func (e *dockerTaskExecutor) Clean(task *Task) error {
 	if err != nil {
 		return err
 	}
+	// The container may have been reaped already or never created.
 	if id == "" {
-		return errors.New("container not found for task")
+		return nil
 	}
 	return e.client.ContainerRemove(context.Background(), id, types.ContainerRemoveOptions{})
 }
 
+// Lists the containers on the Docker host that this worker created for a Task.
+func (e *dockerTaskExecutor) Executions(ctx context.Context) ([]*Execution, error) {
+	list, err := ListTaskContainers(ctx, e.client, filters.Arg("label", LabelWorker+"="+e.WorkerID))
+	if err != nil {
+		return nil, err
+	}
+
+	executions := make([]*Execution, 0, len(list))
+	for _, cntnr := range list {
+		executions = append(executions, &Execution{
+			ID:       cntnr.ID,
+			TaskHash: cntnr.Labels[LabelTaskHash],
+			State:    cntnr.State,
+			Executor: e.Name(),
+		})
+	}
+	return executions, nil
+}
+
+// Lists the containers on the Docker host that were created for a Task, by any worker.
+func ListTaskContainers(ctx context.Context, cli *docker.Client, filter ...filters.KeyValuePair) ([]types.Container, error) {
+	args := filters.NewArgs(append(filter, filters.Arg("label", LabelTaskHash))...)
+	return cli.ContainerList(ctx, types.ContainerListOptions{All: true, Filters: args})
+}
+
+// Removes a container, killing it first if it is still running, and the files of its Task's secrets.
+func (e *dockerTaskExecutor) Reap(ctx context.Context, execution *Execution) error {
+	if err := e.client.ContainerRemove(ctx, execution.ID, types.ContainerRemoveOptions{Force: true}); err != nil {
+		return err
+	}
+	return removeSecretsOf(execution.TaskHash, e.SecretsDir)
+}
+
+func (e *dockerTaskExecutor) SweepSecrets(keep map[string]bool) error {
+	return sweepSecrets(e.SecretsDir, keep)
+}
+
+// Starts the container if it was created but never started, recording the image it was created from on the resumed
+// Task.
+func (e *dockerTaskExecutor) startCreated(ctx context.Context, task *Task, containerID string) error {
+	info, err := e.client.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return err
+	}
+	if info.Config != nil {
+		task.ImageDigest = info.Config.Image
+	}
+	if info.State == nil || info.State.Status != "created" {
+		return nil
+	}
+	return e.client.ContainerStart(ctx, containerID, types.ContainerStartOptions{})
+}
+
 func (e *dockerTaskExecutor) makeResult(task *Task, execErr error) (*ExecutionResult, error) {
 	id, err := e.containerIDForTask(task)
 	if err != nil {
@@ -136,116 +291,115 @@ func (e *dockerTaskExecutor) makeResult(task *Task, execErr error) (*ExecutionRe
 		return nil, errors.New("container not found for task")
 	}
 
-	fmt.Println("results:")
-	fmt.Println(execErr)
-	fmt.Println(task.Workspace.OutputDir)
-
 	result := &ExecutionResult{
 		Err:           execErr,
 		OutputPath:    task.Workspace.OutputDir, //filepath.Join(task.Workspace.OutputDir, "upload")
 		MetadataPaths: make(map[string]string),
 	}
 
-	// logName, logPath, err := e.writeLogs(id, task)
-	// if err == nil {
-	// 	result.MetadataPaths[logName] = logPath
-	// }
+	// Failing to collect logs does not fail the Task, but a failed Task without its logs is hard to diagnose.
+	logs, err := e.writeLogs(id, task)
+	if err != nil {
+		level.Error(e.Logger).Log("msg", "failed to collect container logs", "task", task.Hash(), "container", id,
+			"err", err)
+		return result, nil
+	}
+	result.MetadataPaths = logs.Paths()
+	if exitErr, ok := execErr.(*ExitError); ok {
+		exitErr.Stderr = logs.StderrTail()
+	}
 
-	return result, err
+	return result, nil
 }
 
 func (e *dockerTaskExecutor) containerIDForTask(task *Task) (string, error) {
-	list, err := e.client.ContainerList(context.Background(), types.ContainerListOptions{All: true})
+	list, err := ListTaskContainers(context.Background(), e.client, filters.Arg("label", LabelTaskHash+"="+task.Hash()))
 	if err != nil {
 		return "", err
 	}
-
-	// fmt.Println("list containers on client")
-	// fmt.Println(list)
-
-	// Is the image we are looking for on the local docker host? print them out below
-	// images, err := e.client.ImageList(context.Background(), types.ImageListOptions{All: true})
-	// if err != nil {
-	// 	return "", err
-	// }
-	// fmt.Println("list images on client")
-	// fmt.Println(images)
-
-	for _, cntnr := range list {
-		for _, name := range cntnr.Names {
-			if strings.TrimLeft(name, "/") == task.Hash() {
-				return cntnr.ID, nil
-			}
-		}
+	if len(list) == 0 {
+		return "", nil
 	}
-	return "", nil
+	return list[0].ID, nil
 }
 
-func (e *dockerTaskExecutor) pullImage(ctx context.Context, image string) error {
-	fmt.Println("pull image: " + image)
-	out, err := e.client.ImagePull(ctx, image, types.ImagePullOptions{})
+func (e *dockerTaskExecutor) makeContainer(ctx context.Context, image string, task *Task) (container.ContainerCreateCreatedBody, error) {
+	limits, err := containerLimits(task, e.DefaultLimits, e.MaxLimits)
 	if err != nil {
-		fmt.Println("error pulling image")
-		return err
+		return container.ContainerCreateCreatedBody{}, err
 	}
-	return out.Close()
-}
-
-func (e *dockerTaskExecutor) makeContainer(ctx context.Context, image string, task *Task) (container.ContainerCreateCreatedBody, error) {
-	// define the volume bindings for the container
-	// files added to the local input directory (~/chyme/<task num>/input) will be placed on the container at /in
-	// files added to /out on the container after processing will end up in ~/chyme/<task num>/output
-	in := "/" + task.Workspace.InputDir + ":/in"
-	out := "/" + task.Workspace.OutputDir + ":/out"
-
-	env := make([]string, 0)
-	if envStr := task.ExecutionStrategy.Config["env"]; envStr != "" {
-		env = e.envStrToSlice(envStr)
+	spec, err := parseContainerSpec(task, e.MountRoots)
+	if err != nil {
+		return container.ContainerCreateCreatedBody{}, err
+	}
+	var tmpfs map[string]string
+	if spec.ScratchSize != 0 {
+		tmpfs = map[string]string{ScratchDir: spec.scratchOptions()}
+	}
+	secrets, err := resolveSecrets(task, e.Secrets, e.SecretsDir)
+	if err != nil {
+		return container.ContainerCreateCreatedBody{}, err
+	}
+	binds := spec.Binds
+	if bind := secrets.bind(); bind != "" {
+		binds = append(binds, bind)
 	}
 
 	// User is the user that will run the commands inside the container: this user needs to exist on the container
 	return e.client.ContainerCreate(ctx, &container.Config{
-		Image:        image,
-		User:         e.user,
-		Tty:          true,
+		Image: image,
+		User:  e.User,
+		// Without a TTY the container's stdout and stderr are logged as separate streams.
+		Tty:          false,
 		AttachStdout: true,
 		AttachStderr: true,
-		Env:          env,
+		Env:          append(containerEnv(task), secrets.Env...),
+		Labels:       containerLabels(task, e.WorkerID),
+		Entrypoint:   strslice.StrSlice(spec.Entrypoint),
+		Cmd:          strslice.StrSlice(spec.Cmd),
+		WorkingDir:   spec.WorkingDir,
 	}, &container.HostConfig{
-		Binds: []string{in, out},
+		Binds:       binds,
+		NetworkMode: container.NetworkMode(spec.Network),
+		Tmpfs:       tmpfs,
+		ShmSize:     limits.ShmSize,
+		Resources: container.Resources{
+			NanoCPUs:   limits.NanoCPUs,
+			Memory:     limits.Memory,
+			MemorySwap: limits.MemorySwap,
+			PidsLimit:  limits.PidsLimit,
+			Ulimits:    limits.DockerUlimits(),
+		},
 	}, nil, task.Hash())
 }
 
-func (e *dockerTaskExecutor) killContainer(ctx context.Context, containerID string, signal string) error {
-	return e.client.ContainerKill(ctx, containerID, signal)
-}
-
-// func (e *dockerTaskExecutor) writeLogs(id string, task *Task) (string, string, error) {
-// 	logPath := filepath.Join(task.Workspace.InternalDir, "container-logs.txt")
-// 	logs, err := e.client.ContainerLogs(context.Background(), id, types.ContainerLogsOptions{
-// 		ShowStderr: true,
-// 		ShowStdout: true,
-// 	})
-// 	if err != nil {
-// 		return "", "", err
-// 	}
-// 	defer logs.Close()
-// 	f, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY, 0600)
-// 	if err != nil {
-// 		return "", "", err
-// 	}
-// 	defer f.Close()
-// 	_, err = io.Copy(f, logs)
-// 	return "docker-logs.txt", logPath, nil
-// }
-
-func (e *dockerTaskExecutor) envStrToSlice(envStr string) []string {
-	env := make([]string, 0)
-	if envStr == "" {
-		return env
-	}
-	for _, e := range strings.Split(envStr, "\n") {
-		env = append(env, e)
-	}
-	return env
+// Sends the container SIGTERM, then SIGKILL if it has not exited after the grace period.
+func (e *dockerTaskExecutor) stopContainer(ctx context.Context, containerID string) error {
+	grace := e.StopGracePeriod
+	if grace == 0 {
+		grace = DefaultStopGracePeriod
+	}
+	return e.client.ContainerStop(ctx, containerID, &grace)
+}
+
+// Writes the container's stdout and stderr to the Task's internal directory, each capped at MaxLogSize.
+func (e *dockerTaskExecutor) writeLogs(id string, task *Task) (*taskLogs, error) {
+	out, err := e.client.ContainerLogs(context.Background(), id, types.ContainerLogsOptions{
+		ShowStderr: true,
+		ShowStdout: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer out.Close()
+
+	logs, err := createTaskLogs(task)
+	if err != nil {
+		return nil, err
+	}
+	if err := demuxLogs(out, logs.Stdout(), logs.Stderr()); err != nil {
+		logs.Close()
+		return nil, err
+	}
+	return logs, logs.Close()
 }
@@ -0,0 +1,76 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+)
+
+// A Task implemented in Go and run inside the worker process. It reads its input from the workspace's input
+// directory and writes its output to the output directory, like a container would.
+type InmemExecutable interface {
+	Execute(ctx context.Context, task *Task, workspace *TaskWorkspace) error
+}
+
+// TaskExecutor that runs the InmemExecutable named by the "executable" key of the ExecutionStrategy config.
+type inMemTaskExecutor struct {
+	executables map[string]InmemExecutable
+}
+
+func NewInMemTaskExecutor(executables map[string]InmemExecutable) TaskExecutor {
+	return &inMemTaskExecutor{executables}
+}
+
+func (e *inMemTaskExecutor) Name() string {
+	return ExecutorInmem
+}
+
+func (e *inMemTaskExecutor) Execute(ctx context.Context, task *Task) (*ExecutionResult, error) {
+	if task.ExecutionStrategy.Executor != e.Name() {
+		return nil, fmt.Errorf("invalid executor (%s) should be %s", task.ExecutionStrategy.Executor, e.Name())
+	}
+	if err := task.ExecutionStrategy.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %s", err.Error())
+	}
+	name := task.ExecutionStrategy.Config.Executable
+	executable, ok := e.executables[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown executable %s", name)
+	}
+
+	execCtx := ctx
+	if task.Timeout != 0 {
+		var cancel context.CancelFunc
+		execCtx, cancel = context.WithTimeout(ctx, task.Timeout)
+		defer cancel()
+	}
+
+	execErr := runExecutable(execCtx, executable, task)
+	// The worker is shutting down, rather than the executable failing.
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if execCtx.Err() == context.DeadlineExceeded {
+		execErr = fmt.Errorf("exceeded timeout (%s): %v", task.Timeout.String(), execErr)
+	}
+
+	return &ExecutionResult{
+		Err:           execErr,
+		OutputPath:    task.Workspace.OutputDir,
+		MetadataPaths: make(map[string]string),
+	}, nil
+}
+
+// Runs the executable, converting a panic to an error so that it fails the Task rather than the worker.
+func runExecutable(ctx context.Context, executable InmemExecutable, task *Task) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("executable panicked: %v\n%s", r, debug.Stack())
+		}
+	}()
+	return executable.Execute(ctx, task, task.Workspace)
+}
+
+func (e *inMemTaskExecutor) Clean(task *Task) error {
+	return nil
+}
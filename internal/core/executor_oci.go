@@ -0,0 +1,497 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/hashicorp/go-multierror"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"kroekerlabs.dev/chyme/services/pkg/tracing"
+)
+
+// Configures the TaskExecutor that runs containers with a container runtime CLI.
+type OCIExecutorConfig struct {
+	// CLI of the runtime, podman unless set. Any CLI following Docker's commands and flags works, e.g. nerdctl.
+	Runtime         string
+	WorkerID        string
+	User            string
+	PullPolicy      PullPolicy
+	ShouldRemove    bool
+	DefaultLimits   *ResourceLimits
+	MaxLimits       *ResourceLimits
+	MountRoots      []string
+	RegistryAuth    RegistryAuth
+	Secrets         SecretResolver
+	SecretsDir      string
+	StopGracePeriod time.Duration
+	ShutdownPolicy  ShutdownPolicy
+	// Logger of the executor, which discards the log if nil.
+	Logger log.Logger
+}
+
+// TaskExecutor that runs containers with a container runtime CLI, for hosts without a Docker daemon. Containers
+// have the same layout, labels and lifecycle as those of the Docker executor. Images are pulled with the credentials
+// of the RegistryAuth if it has them, otherwise with those the runtime is logged in with.
+type ociTaskExecutor struct {
+	*OCIExecutorConfig
+}
+
+func NewOCITaskExecutor(config *OCIExecutorConfig) TaskExecutor {
+	config.Logger = loggerOrNop(config.Logger)
+	return &ociTaskExecutor{config}
+}
+
+func (e *ociTaskExecutor) Name() string {
+	return ExecutorOCI
+}
+
+func (e *ociTaskExecutor) runtime() string {
+	if e.Runtime != "" {
+		return e.Runtime
+	}
+	return "podman"
+}
+
+// Processes a task using a container started by the runtime.
+func (e *ociTaskExecutor) Execute(ctx context.Context, task *Task) (*ExecutionResult, error) {
+	if task.ExecutionStrategy.Executor != e.Name() {
+		return nil, fmt.Errorf("invalid executor (%s) should be %s", task.ExecutionStrategy.Executor, e.Name())
+	}
+	if err := task.ExecutionStrategy.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %s", err.Error())
+	}
+	image := task.ExecutionStrategy.Config.Image
+	logger := log.With(e.Logger, "executor", e.Name(), "task", task.Hash(), "template", task.Template)
+
+	localCtx := context.Background()
+
+	containerID, err := e.containerIDForTask(localCtx, task)
+	if err != nil {
+		return nil, err
+	}
+
+	if containerID == "" {
+		if err := e.ensureImage(localCtx, image); err != nil {
+			return nil, err
+		}
+		digest, err := e.resolveImage(localCtx, image)
+		if err != nil {
+			return nil, err
+		}
+		task.ImageDigest = digest
+		level.Debug(logger).Log("msg", "image resolved", "image", image, "digest", digest)
+
+		if containerID, err = e.makeContainer(localCtx, digest, task); err != nil {
+			return nil, err
+		}
+	}
+	if err := e.startCreated(localCtx, task, containerID); err != nil {
+		return nil, err
+	}
+
+	var (
+		timeoutTimer *time.Timer
+		timeoutChan  = make(<-chan time.Time)
+	)
+	if task.Timeout != 0 {
+		timeoutTimer = time.NewTimer(task.Timeout)
+		timeoutChan = timeoutTimer.C
+	}
+
+	_, span := tracing.Tracer().Start(ctx, "container.run", trace.WithAttributes(
+		attribute.String("container.id", containerID),
+		attribute.String("container.image", task.ImageDigest),
+	))
+
+	// The runtime's wait command prints the exit status of the container once it exits.
+	waitCtx, cancelWait := context.WithCancel(localCtx)
+	defer cancelWait()
+	type waitResult struct {
+		out string
+		err error
+	}
+	waitCh := make(chan waitResult, 1)
+	go func() {
+		out, err := e.run(waitCtx, "wait", containerID)
+		waitCh <- waitResult{out, err}
+	}()
+
+	var execErr error
+	// Whether the container is left running for a restarted worker, as of ShutdownLeave.
+	var leftRunning bool
+	select {
+	case <-timeoutChan:
+		level.Warn(logger).Log("msg", "timeout exceeded, stopping container", "container", containerID,
+			"timeout", task.Timeout)
+		if stopErr := e.stopContainer(localCtx, containerID); stopErr != nil {
+			err = fmt.Errorf("exceeded timeout (%s) and failed to stop container: %s", task.Timeout.String(), stopErr.Error())
+		} else {
+			execErr = fmt.Errorf("exceeded timeout (%s), container stopped", task.Timeout.String())
+		}
+	case <-ctx.Done():
+		level.Debug(logger).Log("msg", "processing cancelled", "container", containerID, "policy", e.ShutdownPolicy)
+		err = ctx.Err()
+		leftRunning = e.ShutdownPolicy != ShutdownStop
+		if e.ShutdownPolicy == ShutdownStop {
+			// Removing the container makes the resumed Task start over in a new one.
+			if stopErr := e.stopContainer(localCtx, containerID); stopErr != nil {
+				level.Error(logger).Log("msg", "failed to stop container on shutdown", "container", containerID,
+					"err", stopErr)
+			} else if _, rmErr := e.run(localCtx, "rm", containerID); rmErr != nil {
+				level.Error(logger).Log("msg", "failed to remove container on shutdown", "container", containerID,
+					"err", rmErr)
+			}
+		}
+	case res := <-waitCh:
+		if res.err != nil {
+			err = res.err
+			break
+		}
+		code, convErr := strconv.Atoi(strings.TrimSpace(res.out))
+		if convErr != nil {
+			err = fmt.Errorf("unexpected output of %s wait: %s", e.runtime(), res.out)
+		} else {
+			level.Debug(logger).Log("msg", "container exited", "container", containerID, "code", code)
+			if code != 0 {
+				execErr = &ExitError{Code: code}
+			}
+		}
+	}
+
+	if timeoutTimer != nil {
+		timeoutTimer.Stop()
+	}
+	tracing.End(span, multierror.Append(err, execErr).ErrorOrNil())
+
+	// The secret files are only needed by a container left running for the resumed Task to reattach to.
+	if !leftRunning {
+		if rmErr := removeSecrets(task, e.SecretsDir); rmErr != nil {
+			level.Error(logger).Log("msg", "failed to remove secret files", "err", rmErr)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return e.makeResult(task, containerID, execErr)
+}
+
+func (e *ociTaskExecutor) Clean(task *Task) error {
+	if err := removeSecrets(task, e.SecretsDir); err != nil {
+		return err
+	}
+	if !e.ShouldRemove {
+		return nil
+	}
+
+	id, err := e.containerIDForTask(context.Background(), task)
+	if err != nil {
+		return err
+	}
+	// The container may have been reaped already or never created.
+	if id == "" {
+		return nil
+	}
+	_, err = e.run(context.Background(), "rm", id)
+	return err
+}
+
+// Lists the containers that this worker created for a Task.
+func (e *ociTaskExecutor) Executions(ctx context.Context) ([]*Execution, error) {
+	ids, err := e.listContainers(ctx, LabelWorker+"="+e.WorkerID)
+	if err != nil {
+		return nil, err
+	}
+
+	executions := make([]*Execution, 0, len(ids))
+	for _, id := range ids {
+		out, err := e.run(ctx, "inspect", "--format", `{{index .Config.Labels "`+LabelTaskHash+`"}} {{.State.Status}}`, id)
+		if err != nil {
+			return nil, err
+		}
+		fields := strings.Fields(out)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("unexpected output of %s inspect: %s", e.runtime(), out)
+		}
+		executions = append(executions, &Execution{
+			ID:       id,
+			TaskHash: fields[0],
+			State:    fields[1],
+			Executor: e.Name(),
+		})
+	}
+	return executions, nil
+}
+
+// Removes a container, killing it first if it is still running, and the files of its Task's secrets.
+func (e *ociTaskExecutor) Reap(ctx context.Context, execution *Execution) error {
+	if _, err := e.run(ctx, "rm", "--force", execution.ID); err != nil {
+		return err
+	}
+	return removeSecretsOf(execution.TaskHash, e.SecretsDir)
+}
+
+func (e *ociTaskExecutor) SweepSecrets(keep map[string]bool) error {
+	return sweepSecrets(e.SecretsDir, keep)
+}
+
+func (e *ociTaskExecutor) containerIDForTask(ctx context.Context, task *Task) (string, error) {
+	ids, err := e.listContainers(ctx, LabelTaskHash+"="+task.Hash())
+	if err != nil || len(ids) == 0 {
+		return "", err
+	}
+	return ids[0], nil
+}
+
+// Lists the IDs of the containers created for a Task that have the label.
+func (e *ociTaskExecutor) listContainers(ctx context.Context, label string) ([]string, error) {
+	out, err := e.run(ctx, "ps", "--all", "--no-trunc", "--format", "{{.ID}}",
+		"--filter", "label="+LabelTaskHash, "--filter", "label="+label)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(out), nil
+}
+
+// Makes the image available according to the pull policy.
+func (e *ociTaskExecutor) ensureImage(ctx context.Context, image string) error {
+	switch e.PullPolicy {
+	case PullNever, "":
+		return nil
+	case PullIfNotPresent:
+		if _, err := e.run(ctx, "image", "inspect", "--format", "{{.Id}}", image); err == nil {
+			return nil
+		}
+	}
+	level.Info(e.Logger).Log("msg", "pulling image", "image", image)
+	env, cleanup, err := e.pullEnv(image)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	if _, err := e.runWithEnv(ctx, env, "pull", image); err != nil {
+		return fmt.Errorf("failed to pull image %s: %s", image, err.Error())
+	}
+	return nil
+}
+
+// Environment the runtime pulls the image with. The credentials of the RegistryAuth are written to an auth file in
+// the secrets directory, which Docker's CLI and nerdctl find through DOCKER_CONFIG and podman through
+// REGISTRY_AUTH_FILE. The returned func removes it.
+func (e *ociTaskExecutor) pullEnv(image string) ([]string, func(), error) {
+	if e.RegistryAuth == nil {
+		return nil, func() {}, nil
+	}
+	auth, err := e.RegistryAuth.AuthFor(image)
+	if err != nil || auth == "" {
+		return nil, func() {}, err
+	}
+	config, err := authFile(auth)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	root := secretsDirOrDefault(e.SecretsDir)
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return nil, nil, err
+	}
+	dir, err := ioutil.TempDir(root, "auth-")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() {
+		if err := os.RemoveAll(dir); err != nil {
+			level.Error(e.Logger).Log("msg", "failed to remove registry auth file", "err", err)
+		}
+	}
+	path := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(path, config, 0600); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return []string{"DOCKER_CONFIG=" + dir, "REGISTRY_AUTH_FILE=" + path}, cleanup, nil
+}
+
+// Resolves the image to its repository digest if it was pulled from a registry, otherwise its ID.
+func (e *ociTaskExecutor) resolveImage(ctx context.Context, image string) (string, error) {
+	if strings.ContainsRune(image, '@') {
+		return image, nil
+	}
+	out, err := e.run(ctx, "image", "inspect", "--format", "{{json .RepoDigests}} {{.Id}}", image)
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect image %s: %s", image, err.Error())
+	}
+	out = strings.TrimSpace(out)
+	i := strings.LastIndex(out, " ")
+	var digests []string
+	if i == -1 || json.Unmarshal([]byte(out[:i]), &digests) != nil {
+		return "", fmt.Errorf("unexpected output of %s image inspect: %s", e.runtime(), out)
+	}
+
+	repository := imageRepository(image)
+	for _, digest := range digests {
+		if imageRepository(digest) == repository {
+			return digest, nil
+		}
+	}
+	if len(digests) > 0 {
+		return digests[0], nil
+	}
+	return out[i+1:], nil
+}
+
+// Creates the Task's container, returning its ID.
+func (e *ociTaskExecutor) makeContainer(ctx context.Context, image string, task *Task) (string, error) {
+	limits, err := containerLimits(task, e.DefaultLimits, e.MaxLimits)
+	if err != nil {
+		return "", err
+	}
+	spec, err := parseContainerSpec(task, e.MountRoots)
+	if err != nil {
+		return "", err
+	}
+	secrets, err := resolveSecrets(task, e.Secrets, e.SecretsDir)
+	if err != nil {
+		return "", err
+	}
+
+	args := []string{"create", "--name", task.Hash(), "--network", spec.Network}
+	if e.User != "" {
+		args = append(args, "--user", e.User)
+	}
+	for _, bind := range spec.Binds {
+		args = append(args, "--volume", bind)
+	}
+	if bind := secrets.bind(); bind != "" {
+		args = append(args, "--volume", bind)
+	}
+	if spec.ScratchSize != 0 {
+		args = append(args, "--tmpfs", ScratchDir+":"+spec.scratchOptions())
+	}
+	if spec.WorkingDir != "" {
+		args = append(args, "--workdir", spec.WorkingDir)
+	}
+	if len(spec.Entrypoint) > 0 {
+		// A JSON array sets an entrypoint with arguments.
+		entrypoint, err := json.Marshal(spec.Entrypoint)
+		if err != nil {
+			return "", err
+		}
+		args = append(args, "--entrypoint", string(entrypoint))
+	}
+	for _, env := range containerEnv(task) {
+		args = append(args, "--env", env)
+	}
+	// Secret values would be visible in the process list as arguments, so the CLI takes them from its environment.
+	for _, env := range secrets.Env {
+		args = append(args, "--env", env[:strings.IndexRune(env, '=')])
+	}
+	for k, v := range containerLabels(task, e.WorkerID) {
+		args = append(args, "--label", k+"="+v)
+	}
+	args = append(args, limits.RuntimeArgs()...)
+	args = append(args, image)
+	args = append(args, spec.Cmd...)
+
+	out, err := e.runWithEnv(ctx, secrets.Env, args...)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// Starts the container unless it has been started already, recording the image it was created from on the Task.
+func (e *ociTaskExecutor) startCreated(ctx context.Context, task *Task, containerID string) error {
+	out, err := e.run(ctx, "inspect", "--format", "{{.State.Status}} {{.Config.Image}}", containerID)
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(out)
+	if len(fields) != 2 {
+		return fmt.Errorf("unexpected output of %s inspect: %s", e.runtime(), out)
+	}
+	task.ImageDigest = fields[1]
+	if fields[0] != "created" {
+		return nil
+	}
+	_, err = e.run(ctx, "start", containerID)
+	return err
+}
+
+// Sends the container SIGTERM, then SIGKILL if it has not exited after the grace period.
+func (e *ociTaskExecutor) stopContainer(ctx context.Context, containerID string) error {
+	grace := e.StopGracePeriod
+	if grace == 0 {
+		grace = DefaultStopGracePeriod
+	}
+	_, err := e.run(ctx, "stop", "--time", strconv.Itoa(int(grace.Seconds())), containerID)
+	return err
+}
+
+func (e *ociTaskExecutor) makeResult(task *Task, containerID string, execErr error) (*ExecutionResult, error) {
+	result := &ExecutionResult{
+		Err:           execErr,
+		OutputPath:    task.Workspace.OutputDir,
+		MetadataPaths: make(map[string]string),
+	}
+	logger := log.With(e.Logger, "task", task.Hash(), "container", containerID)
+
+	// Failing to collect logs does not fail the Task, but a failed Task without its logs is hard to diagnose.
+	logs, err := createTaskLogs(task)
+	if err != nil {
+		level.Error(logger).Log("msg", "failed to collect container logs", "err", err)
+		return result, nil
+	}
+	cmd := exec.Command(e.runtime(), "logs", containerID)
+	cmd.Stdout = logs.Stdout()
+	cmd.Stderr = logs.Stderr()
+	if err := cmd.Run(); err != nil {
+		logs.Close()
+		level.Error(logger).Log("msg", "failed to collect container logs", "err", err)
+		return result, nil
+	}
+	if err := logs.Close(); err != nil {
+		level.Error(logger).Log("msg", "failed to collect container logs", "err", err)
+		return result, nil
+	}
+	result.MetadataPaths = logs.Paths()
+	if exitErr, ok := execErr.(*ExitError); ok {
+		exitErr.Stderr = logs.StderrTail()
+	}
+
+	return result, nil
+}
+
+// Runs a command of the runtime CLI, returning its stdout. Its stderr is the error if it fails.
+func (e *ociTaskExecutor) run(ctx context.Context, args ...string) (string, error) {
+	return e.runWithEnv(ctx, nil, args...)
+}
+
+// Runs a command of the runtime CLI with env added to the worker's environment.
+func (e *ociTaskExecutor) runWithEnv(ctx context.Context, env []string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, e.runtime(), args...)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%s %s: %s", e.runtime(), args[0], msg)
+		}
+		return "", fmt.Errorf("%s %s: %s", e.runtime(), args[0], err.Error())
+	}
+	return stdout.String(), nil
+}
@@ -0,0 +1,201 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/hashicorp/go-multierror"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"kroekerlabs.dev/chyme/services/pkg/tracing"
+)
+
+// Configures the TaskExecutor that runs commands on the host.
+type ProcessExecutorConfig struct {
+	// Host environment variables passed on to commands, e.g. PATH. Others are not, so the worker's credentials
+	// don't leak into Tasks.
+	InheritEnv []string
+	// Time a timed out command is given to exit after SIGTERM before it is sent SIGKILL.
+	StopGracePeriod time.Duration
+	// Logger of the executor, which discards the log if nil.
+	Logger log.Logger
+}
+
+// TaskExecutor that runs a command on the host, for Tasks that don't need a container.
+//
+// The first element of the config's Command is the executable, looked up in PATH if it has no slash; the rest of
+// Command and Args are its arguments. The working directory is the Task's output directory unless Workdir is set.
+// Commands see CH_INPUT_DIR and CH_OUTPUT_DIR, which arguments may reference as ${CH_INPUT_DIR} and ${CH_OUTPUT_DIR}.
+type processTaskExecutor struct {
+	*ProcessExecutorConfig
+}
+
+func NewProcessTaskExecutor(config *ProcessExecutorConfig) TaskExecutor {
+	config.Logger = loggerOrNop(config.Logger)
+	return &processTaskExecutor{config}
+}
+
+func (e *processTaskExecutor) Name() string {
+	return ExecutorProcess
+}
+
+// Runs the Task's command and waits for it to exit.
+func (e *processTaskExecutor) Execute(ctx context.Context, task *Task) (*ExecutionResult, error) {
+	if task.ExecutionStrategy.Executor != e.Name() {
+		return nil, fmt.Errorf("invalid executor (%s) should be %s", task.ExecutionStrategy.Executor, e.Name())
+	}
+	if err := task.ExecutionStrategy.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %s", err.Error())
+	}
+	config := task.ExecutionStrategy.Config
+
+	env := e.taskEnv(task)
+	expand := func(s string) string {
+		return os.Expand(s, func(key string) string { return env[key] })
+	}
+
+	args := make([]string, 0)
+	for _, arg := range config.Command[1:] {
+		args = append(args, expand(arg))
+	}
+	for _, arg := range config.Args {
+		args = append(args, expand(arg))
+	}
+	cmd := exec.Command(expand(config.Command[0]), args...)
+	cmd.Dir = task.Workspace.OutputDir
+	if config.Workdir != "" {
+		cmd.Dir = expand(config.Workdir)
+	}
+	for k, v := range env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	// The command gets its own process group, so stopping it also stops the processes it started.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	logs, err := createTaskLogs(task)
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stdout = logs.Stdout()
+	cmd.Stderr = logs.Stderr()
+
+	logger := log.With(e.Logger, "executor", e.Name(), "task", task.Hash(), "template", task.Template)
+	if err := cmd.Start(); err != nil {
+		logs.Close()
+		return nil, fmt.Errorf("failed to start command: %s", err.Error())
+	}
+	level.Debug(logger).Log("msg", "command started", "command", cmd.Path, "pid", cmd.Process.Pid)
+	_, span := tracing.Tracer().Start(ctx, "command.run", trace.WithAttributes(
+		attribute.String("command.path", cmd.Path),
+	))
+	execErr, err := e.wait(ctx, cmd, task.Timeout)
+	tracing.End(span, multierror.Append(err, execErr).ErrorOrNil())
+	if closeErr := logs.Close(); err == nil && closeErr != nil {
+		level.Error(logger).Log("msg", "failed to write command logs", "err", closeErr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if exitErr, ok := execErr.(*ExitError); ok {
+		exitErr.Stderr = logs.StderrTail()
+	}
+	return &ExecutionResult{
+		Err:           execErr,
+		OutputPath:    task.Workspace.OutputDir,
+		MetadataPaths: logs.Paths(),
+	}, nil
+}
+
+// Waits for the command to exit, stopping it if it exceeds the timeout or the context is canceled.
+func (e *processTaskExecutor) wait(ctx context.Context, cmd *exec.Cmd, timeout time.Duration) (execErr error, err error) {
+	doneCh := make(chan error, 1)
+	go func() { doneCh <- cmd.Wait() }()
+
+	timeoutChan := make(<-chan time.Time)
+	if timeout != 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutChan = timer.C
+	}
+
+	select {
+	case err := <-doneCh:
+		return exitError(err), nil
+	case <-timeoutChan:
+		e.stop(cmd, doneCh)
+		return fmt.Errorf("exceeded timeout (%s), command stopped", timeout.String()), nil
+	case <-ctx.Done():
+		// A command does not outlive the worker, so the resumed Task runs it again.
+		e.stop(cmd, doneCh)
+		return nil, ctx.Err()
+	}
+}
+
+// Sends the command's process group SIGTERM, then SIGKILL if it has not exited after the grace period.
+func (e *processTaskExecutor) stop(cmd *exec.Cmd, doneCh <-chan error) {
+	grace := e.StopGracePeriod
+	if grace == 0 {
+		grace = DefaultStopGracePeriod
+	}
+	_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+	select {
+	case <-doneCh:
+	case <-time.After(grace):
+		_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-doneCh
+	}
+}
+
+// Environment of the Task's command: the inherited host variables, the configured env, the workspace directories
+// and the Task's chunk.
+func (e *processTaskExecutor) taskEnv(task *Task) map[string]string {
+	env := make(map[string]string)
+	for _, key := range e.InheritEnv {
+		if value, ok := os.LookupEnv(key); ok {
+			env[key] = value
+		}
+	}
+	for k, v := range task.ExecutionStrategy.Config.Env {
+		env[k] = v
+	}
+	if task.Chunk != nil {
+		for _, kv := range task.Chunk.Env() {
+			if i := strings.IndexRune(kv, '='); i > 0 {
+				env[kv[:i]] = kv[i+1:]
+			}
+		}
+	}
+	env["CH_INPUT_DIR"] = task.Workspace.InputDir
+	env["CH_OUTPUT_DIR"] = task.Workspace.OutputDir
+	return env
+}
+
+func (e *processTaskExecutor) Clean(task *Task) error {
+	return nil
+}
+
+// Converts the error of a command that ran to an ExitError.
+func exitError(err error) error {
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return &ExitError{Code: exitErr.ExitCode()}
+	}
+	return err
+}
+
+func splitLines(s string) []string {
+	lines := make([]string, 0)
+	for _, line := range strings.Split(s, "\n") {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
@@ -0,0 +1,227 @@
+package core
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"docker.io/go-docker"
+	"docker.io/go-docker/api/types"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"kroekerlabs.dev/chyme/services/pkg/vault"
+)
+
+// When the Docker executor pulls a Task's image.
+type PullPolicy string
+
+const (
+	PullAlways       PullPolicy = "always"
+	PullIfNotPresent PullPolicy = "if-not-present"
+	PullNever        PullPolicy = "never"
+)
+
+// Parses a pull policy. For compatibility, boolean values map to always (true) and never (false).
+func ParsePullPolicy(policy string) (PullPolicy, error) {
+	switch p := PullPolicy(policy); p {
+	case "":
+		return PullNever, nil
+	case PullAlways, PullIfNotPresent, PullNever:
+		return p, nil
+	}
+
+	pull, err := strconv.ParseBool(policy)
+	if err != nil {
+		return "", fmt.Errorf("invalid pull policy %s: must be %s, %s or %s", policy, PullAlways, PullIfNotPresent, PullNever)
+	}
+	if pull {
+		return PullAlways, nil
+	}
+	return PullNever, nil
+}
+
+// Registry images are pulled from when their name does not start with one.
+const DefaultRegistry = "docker.io"
+
+// Supplies the credentials Docker and the OCI executor pull images with.
+type RegistryAuth interface {
+	// Returns the base64-encoded auth config for the registry the image is pulled from, or "" to pull anonymously.
+	AuthFor(image string) (string, error)
+}
+
+type vaultRegistryAuth struct {
+	client *vault.Client
+	prefix string
+}
+
+// Creates a RegistryAuth that reads the username and password fields of the Vault secret at <prefix>/<registry>,
+// e.g. secret/chyme/registries/ghcr.io. Images of registries without a secret are pulled anonymously.
+func NewVaultRegistryAuth(client *vault.Client, prefix string) RegistryAuth {
+	return &vaultRegistryAuth{client, strings.TrimRight(prefix, "/")}
+}
+
+func (a *vaultRegistryAuth) AuthFor(image string) (string, error) {
+	registry := registryHost(image)
+	data, err := a.client.ReadSecret(a.prefix + "/" + registry)
+	if errors.Is(err, vault.ErrSecretNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read credentials of registry %s: %s", registry, err.Error())
+	}
+
+	username, _ := data["username"].(string)
+	password, _ := data["password"].(string)
+	auth, err := json.Marshal(types.AuthConfig{
+		Username:      username,
+		Password:      password,
+		ServerAddress: registry,
+	})
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(auth), nil
+}
+
+// Converts the auth config RegistryAuth returns into the auth file of a container runtime CLI, which holds the
+// credentials of the registry as base64 of username:password.
+func authFile(auth string) ([]byte, error) {
+	decoded, err := base64.URLEncoding.DecodeString(auth)
+	if err != nil {
+		return nil, fmt.Errorf("invalid registry auth: %s", err.Error())
+	}
+	var config types.AuthConfig
+	if err := json.Unmarshal(decoded, &config); err != nil {
+		return nil, fmt.Errorf("invalid registry auth: %s", err.Error())
+	}
+	credentials := base64.StdEncoding.EncodeToString([]byte(config.Username + ":" + config.Password))
+	return json.Marshal(map[string]interface{}{
+		"auths": map[string]interface{}{
+			config.ServerAddress: map[string]string{"auth": credentials},
+		},
+	})
+}
+
+// Returns the registry an image is pulled from: the first component of its name if it looks like a host.
+func registryHost(image string) string {
+	i := strings.IndexRune(image, '/')
+	if i == -1 {
+		return DefaultRegistry
+	}
+	host := image[:i]
+	if !strings.ContainsAny(host, ".:") && host != "localhost" {
+		return DefaultRegistry
+	}
+	return host
+}
+
+// Returns the image name without its tag or digest.
+func imageRepository(image string) string {
+	if i := strings.IndexRune(image, '@'); i != -1 {
+		image = image[:i]
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	return image
+}
+
+// Makes the image available on the Docker host according to the pull policy.
+func (e *dockerTaskExecutor) ensureImage(ctx context.Context, image string) error {
+	switch e.PullPolicy {
+	case PullNever, "":
+		return nil
+	case PullIfNotPresent:
+		_, _, err := e.client.ImageInspectWithRaw(ctx, image)
+		if err == nil {
+			return nil
+		}
+		if !docker.IsErrNotFound(err) {
+			return err
+		}
+	}
+	return e.pullImage(ctx, image)
+}
+
+func (e *dockerTaskExecutor) pullImage(ctx context.Context, image string) error {
+	level.Info(e.Logger).Log("msg", "pulling image", "image", image)
+	options := types.ImagePullOptions{}
+	if e.RegistryAuth != nil {
+		auth, err := e.RegistryAuth.AuthFor(image)
+		if err != nil {
+			return err
+		}
+		options.RegistryAuth = auth
+	}
+
+	out, err := e.client.ImagePull(ctx, image, options)
+	if err != nil {
+		return fmt.Errorf("failed to pull image %s: %s", image, err.Error())
+	}
+	defer out.Close()
+	if err := logPullProgress(e.Logger, image, out); err != nil {
+		return fmt.Errorf("failed to pull image %s: %s", image, err.Error())
+	}
+	return nil
+}
+
+// A message of the progress stream of an image pull.
+type pullMessage struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Error  string `json:"error"`
+}
+
+// Logs each change of status of the layers of an image pull, returning the error the pull ends with, if any.
+func logPullProgress(logger log.Logger, image string, r io.Reader) error {
+	statuses := make(map[string]string)
+	dec := json.NewDecoder(r)
+	for {
+		var msg pullMessage
+		if err := dec.Decode(&msg); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if msg.Error != "" {
+			return errors.New(msg.Error)
+		}
+		if statuses[msg.ID] == msg.Status {
+			continue
+		}
+		statuses[msg.ID] = msg.Status
+		if msg.ID != "" {
+			level.Debug(logger).Log("msg", "pull progress", "image", image, "layer", msg.ID, "status", msg.Status)
+		} else {
+			level.Debug(logger).Log("msg", "pull progress", "image", image, "status", msg.Status)
+		}
+	}
+}
+
+// Resolves the image to a reference to exactly the image Docker has: its repository digest if it was pulled from a
+// registry, otherwise its ID.
+func (e *dockerTaskExecutor) resolveImage(ctx context.Context, image string) (string, error) {
+	if strings.ContainsRune(image, '@') {
+		return image, nil
+	}
+	info, _, err := e.client.ImageInspectWithRaw(ctx, image)
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect image %s: %s", image, err.Error())
+	}
+
+	repository := imageRepository(image)
+	for _, digest := range info.RepoDigests {
+		if imageRepository(digest) == repository {
+			return digest, nil
+		}
+	}
+	if len(info.RepoDigests) > 0 {
+		return info.RepoDigests[0], nil
+	}
+	return info.ID, nil
+}
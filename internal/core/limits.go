@@ -0,0 +1,213 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/go-units"
+)
+
+// ResourceLimits constrains the host resources available to a Task's container. Zero values are unlimited.
+type ResourceLimits struct {
+	NanoCPUs   int64
+	Memory     int64
+	MemorySwap int64 // -1 allows unlimited swap
+	PidsLimit  int64
+	ShmSize    int64
+	Ulimits    map[string]Ulimit
+}
+
+type Ulimit struct {
+	Soft int64 `json:"soft" yaml:"soft"`
+	Hard int64 `json:"hard" yaml:"hard"`
+}
+
+// Keys of worker limit strings, and of the limits in version 0 ExecutionStrategy configs.
+const (
+	LimitCPUs       = "cpus"        // fractional number of CPUs, e.g. 1.5
+	LimitMemory     = "memory"      // bytes with an optional unit, e.g. 512m or 4g
+	LimitMemorySwap = "memory_swap" // as memory, or -1
+	LimitPids       = "pids_limit"  // maximum number of processes
+	LimitShmSize    = "shm_size"    // as memory
+	LimitUlimits    = "ulimits"     // comma-separated name=soft[:hard], e.g. nofile=1024:2048,nproc=512
+)
+
+// Parses ResourceLimits from the limit keys of a config map. Other keys are ignored.
+func ParseResourceLimits(config map[string]string) (*ResourceLimits, error) {
+	l := &ResourceLimits{}
+	var err error
+
+	if v := config[LimitCPUs]; v != "" {
+		cpus, err := strconv.ParseFloat(v, 64)
+		if err != nil || cpus < 0 {
+			return nil, fmt.Errorf("invalid %s %q", LimitCPUs, v)
+		}
+		l.NanoCPUs = int64(cpus * 1e9)
+	}
+	if l.Memory, err = parseBytes(config, LimitMemory); err != nil {
+		return nil, err
+	}
+	if v := config[LimitMemorySwap]; v == "-1" {
+		l.MemorySwap = -1
+	} else if l.MemorySwap, err = parseBytes(config, LimitMemorySwap); err != nil {
+		return nil, err
+	}
+	if v := config[LimitPids]; v != "" {
+		if l.PidsLimit, err = strconv.ParseInt(v, 10, 64); err != nil || l.PidsLimit < 0 {
+			return nil, fmt.Errorf("invalid %s %q", LimitPids, v)
+		}
+	}
+	if l.ShmSize, err = parseBytes(config, LimitShmSize); err != nil {
+		return nil, err
+	}
+	if v := config[LimitUlimits]; v != "" {
+		l.Ulimits = make(map[string]Ulimit)
+		for _, s := range strings.Split(v, ",") {
+			ulimit, err := units.ParseUlimit(strings.TrimSpace(s))
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %s", LimitUlimits, err.Error())
+			}
+			l.Ulimits[ulimit.Name] = Ulimit{ulimit.Soft, ulimit.Hard}
+		}
+	}
+	return l, nil
+}
+
+// Parses worker-level limits of the form key=value;key=value using the limit keys, e.g.
+// "cpus=2;memory=4g;ulimits=nofile=1024:2048".
+func ParseResourceLimitsString(s string) (*ResourceLimits, error) {
+	config := make(map[string]string)
+	for _, pair := range strings.Split(s, ";") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid limit %q, expected key=value", pair)
+		}
+		config[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return ParseResourceLimits(config)
+}
+
+// Returns the limits to apply to a container: unset values of l are taken from defaults, and values still unset after
+// that are taken from max, so a Task is never unlimited where the worker has a maximum. It is an error for any value
+// to exceed max.
+func (l *ResourceLimits) Resolve(defaults *ResourceLimits, max *ResourceLimits) (*ResourceLimits, error) {
+	if defaults == nil {
+		defaults = &ResourceLimits{}
+	}
+	if max == nil {
+		max = &ResourceLimits{}
+	}
+
+	r := &ResourceLimits{Ulimits: make(map[string]Ulimit)}
+	for _, f := range []struct {
+		name                  string
+		dst                   *int64
+		value, fallback, high int64
+	}{
+		{LimitCPUs, &r.NanoCPUs, l.NanoCPUs, defaults.NanoCPUs, max.NanoCPUs},
+		{LimitMemory, &r.Memory, l.Memory, defaults.Memory, max.Memory},
+		{LimitMemorySwap, &r.MemorySwap, l.MemorySwap, defaults.MemorySwap, max.MemorySwap},
+		{LimitPids, &r.PidsLimit, l.PidsLimit, defaults.PidsLimit, max.PidsLimit},
+		{LimitShmSize, &r.ShmSize, l.ShmSize, defaults.ShmSize, max.ShmSize},
+	} {
+		v, err := resolveLimit(f.name, f.value, f.fallback, f.high)
+		if err != nil {
+			return nil, err
+		}
+		*f.dst = v
+	}
+
+	for name, u := range max.Ulimits {
+		r.Ulimits[name] = u
+	}
+	for name, u := range defaults.Ulimits {
+		r.Ulimits[name] = u
+	}
+	for name, u := range l.Ulimits {
+		if m, ok := max.Ulimits[name]; ok && (u.Soft > m.Soft || u.Hard > m.Hard) {
+			return nil, fmt.Errorf("ulimit %s %d:%d exceeds worker maximum %d:%d", name, u.Soft, u.Hard, m.Soft, m.Hard)
+		}
+		r.Ulimits[name] = u
+	}
+	return r, nil
+}
+
+func resolveLimit(name string, value int64, fallback int64, max int64) (int64, error) {
+	if value == 0 {
+		value = fallback
+	}
+	if max == 0 {
+		return value, nil
+	}
+	if value == 0 {
+		return max, nil
+	}
+	if value < 0 || value > max {
+		if name == LimitCPUs {
+			return 0, fmt.Errorf("%s %g exceeds worker maximum %g", name, float64(value)/1e9, float64(max)/1e9)
+		}
+		return 0, fmt.Errorf("%s %d exceeds worker maximum %d", name, value, max)
+	}
+	return value, nil
+}
+
+// Ulimits in the form used by the Docker API, sorted by name.
+func (l *ResourceLimits) DockerUlimits() []*units.Ulimit {
+	names := make([]string, 0, len(l.Ulimits))
+	for name := range l.Ulimits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ulimits := make([]*units.Ulimit, len(names))
+	for i, name := range names {
+		ulimits[i] = &units.Ulimit{Name: name, Soft: l.Ulimits[name].Soft, Hard: l.Ulimits[name].Hard}
+	}
+	return ulimits
+}
+
+func (l *ResourceLimits) isZero() bool {
+	return l.NanoCPUs == 0 && l.Memory == 0 && l.MemorySwap == 0 && l.PidsLimit == 0 && l.ShmSize == 0 &&
+		len(l.Ulimits) == 0
+}
+
+// Limits as the flags of a container runtime CLI that follows Docker's, e.g. podman run.
+func (l *ResourceLimits) RuntimeArgs() []string {
+	args := make([]string, 0)
+	if l.NanoCPUs != 0 {
+		args = append(args, "--cpus", strconv.FormatFloat(float64(l.NanoCPUs)/1e9, 'f', -1, 64))
+	}
+	if l.Memory != 0 {
+		args = append(args, "--memory", strconv.FormatInt(l.Memory, 10))
+	}
+	if l.MemorySwap != 0 {
+		args = append(args, "--memory-swap", strconv.FormatInt(l.MemorySwap, 10))
+	}
+	if l.PidsLimit != 0 {
+		args = append(args, "--pids-limit", strconv.FormatInt(l.PidsLimit, 10))
+	}
+	if l.ShmSize != 0 {
+		args = append(args, "--shm-size", strconv.FormatInt(l.ShmSize, 10))
+	}
+	for _, u := range l.DockerUlimits() {
+		args = append(args, "--ulimit", u.String())
+	}
+	return args
+}
+
+func parseBytes(config map[string]string, key string) (int64, error) {
+	v := config[key]
+	if v == "" {
+		return 0, nil
+	}
+	b, err := units.RAMInBytes(v)
+	if err != nil || b < 0 {
+		return 0, fmt.Errorf("invalid %s %q", key, v)
+	}
+	return b, nil
+}
@@ -0,0 +1,173 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseResourceLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  map[string]string
+		want    *ResourceLimits
+		wantErr bool
+	}{
+		{name: "empty", config: map[string]string{}, want: &ResourceLimits{}},
+		{name: "other keys ignored", config: map[string]string{"image": "x", "network": "none"}, want: &ResourceLimits{}},
+		{
+			name: "every key",
+			config: map[string]string{
+				LimitCPUs:       "1.5",
+				LimitMemory:     "512m",
+				LimitMemorySwap: "1g",
+				LimitPids:       "256",
+				LimitShmSize:    "64m",
+				LimitUlimits:    "nofile=1024:2048, nproc=512",
+			},
+			want: &ResourceLimits{
+				NanoCPUs:   1500000000,
+				Memory:     512 << 20,
+				MemorySwap: 1 << 30,
+				PidsLimit:  256,
+				ShmSize:    64 << 20,
+				Ulimits:    map[string]Ulimit{"nofile": {1024, 2048}, "nproc": {512, 512}},
+			},
+		},
+		{name: "plain bytes", config: map[string]string{LimitMemory: "1048576"}, want: &ResourceLimits{Memory: 1 << 20}},
+		{name: "unlimited swap", config: map[string]string{LimitMemorySwap: "-1"}, want: &ResourceLimits{MemorySwap: -1}},
+		{name: "invalid cpus", config: map[string]string{LimitCPUs: "two"}, wantErr: true},
+		{name: "negative cpus", config: map[string]string{LimitCPUs: "-1"}, wantErr: true},
+		{name: "invalid memory", config: map[string]string{LimitMemory: "lots"}, wantErr: true},
+		{name: "negative pids", config: map[string]string{LimitPids: "-1"}, wantErr: true},
+		{name: "invalid ulimit", config: map[string]string{LimitUlimits: "nofile"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseResourceLimits(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %t", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseResourceLimits() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseResourceLimitsString(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    *ResourceLimits
+		wantErr bool
+	}{
+		{name: "empty", s: "", want: &ResourceLimits{}},
+		{
+			name: "pairs",
+			s:    " cpus=2 ; memory = 4g;;ulimits=nofile=1024:2048",
+			want: &ResourceLimits{
+				NanoCPUs: 2000000000,
+				Memory:   4 << 30,
+				Ulimits:  map[string]Ulimit{"nofile": {1024, 2048}},
+			},
+		},
+		{name: "missing value", s: "cpus", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseResourceLimitsString(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %t", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseResourceLimitsString() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResourceLimitsResolve(t *testing.T) {
+	tests := []struct {
+		name     string
+		limits   *ResourceLimits
+		defaults *ResourceLimits
+		max      *ResourceLimits
+		want     *ResourceLimits
+		wantErr  bool
+	}{
+		{
+			name:   "no defaults or max",
+			limits: &ResourceLimits{Memory: 1 << 30},
+			want:   &ResourceLimits{Memory: 1 << 30, Ulimits: map[string]Ulimit{}},
+		},
+		{
+			name:     "unset taken from defaults",
+			limits:   &ResourceLimits{Memory: 1 << 30},
+			defaults: &ResourceLimits{Memory: 2 << 30, PidsLimit: 512},
+			want:     &ResourceLimits{Memory: 1 << 30, PidsLimit: 512, Ulimits: map[string]Ulimit{}},
+		},
+		{
+			name:     "unset after defaults taken from max",
+			limits:   &ResourceLimits{},
+			defaults: &ResourceLimits{NanoCPUs: 1e9},
+			max:      &ResourceLimits{NanoCPUs: 4e9, Memory: 8 << 30},
+			want:     &ResourceLimits{NanoCPUs: 1e9, Memory: 8 << 30, Ulimits: map[string]Ulimit{}},
+		},
+		{
+			name:   "equal to max",
+			limits: &ResourceLimits{Memory: 8 << 30},
+			max:    &ResourceLimits{Memory: 8 << 30},
+			want:   &ResourceLimits{Memory: 8 << 30, Ulimits: map[string]Ulimit{}},
+		},
+		{
+			name:    "over max",
+			limits:  &ResourceLimits{NanoCPUs: 8e9},
+			max:     &ResourceLimits{NanoCPUs: 4e9},
+			wantErr: true,
+		},
+		{
+			name:     "default over max",
+			limits:   &ResourceLimits{},
+			defaults: &ResourceLimits{PidsLimit: 1024},
+			max:      &ResourceLimits{PidsLimit: 512},
+			wantErr:  true,
+		},
+		{
+			name:    "unlimited swap under a max",
+			limits:  &ResourceLimits{MemorySwap: -1},
+			max:     &ResourceLimits{MemorySwap: 4 << 30},
+			wantErr: true,
+		},
+		{
+			name:     "ulimits merged by precedence",
+			limits:   &ResourceLimits{Ulimits: map[string]Ulimit{"nofile": {1024, 2048}}},
+			defaults: &ResourceLimits{Ulimits: map[string]Ulimit{"nofile": {512, 512}, "nproc": {256, 256}}},
+			max:      &ResourceLimits{Ulimits: map[string]Ulimit{"nofile": {4096, 4096}, "core": {0, 0}}},
+			want: &ResourceLimits{Ulimits: map[string]Ulimit{
+				"nofile": {1024, 2048},
+				"nproc":  {256, 256},
+				"core":   {0, 0},
+			}},
+		},
+		{
+			name:    "ulimit over max",
+			limits:  &ResourceLimits{Ulimits: map[string]Ulimit{"nofile": {1024, 8192}}},
+			max:     &ResourceLimits{Ulimits: map[string]Ulimit{"nofile": {4096, 4096}}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.limits.Resolve(tt.defaults, tt.max)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %t", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Resolve() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
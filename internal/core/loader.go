@@ -11,6 +11,8 @@ import (
 	"syscall"
 
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
 	"kroekerlabs.dev/chyme/services/pkg/aws"
 )
 
@@ -18,6 +20,8 @@ type ResourceLoader interface {
 	Scheme() string
 	CheckCapacityPosix(resource *Resource, path string, scaleFactor uint64) (bool, error)
 	Download(ctx context.Context, resource *Resource, path string) (int64, error)
+	// Downloads the byte range of an object covered by chunk into the file or directory at path.
+	DownloadChunk(ctx context.Context, resource *Resource, path string, chunk *Chunk) (int64, error)
 	Upload(ctx context.Context, resource *Resource, path string, metadata map[string]*string, remove bool) (int64, error)
 	Exists(ctx context.Context, resource *Resource) (bool, error)
 	Tag(resource *Resource, tags map[string]string) error
@@ -47,6 +51,14 @@ func (l *resourceLoader) Download(ctx context.Context, resource *Resource, path
 	return loader.Download(ctx, resource, path)
 }
 
+func (l *resourceLoader) DownloadChunk(ctx context.Context, resource *Resource, path string, chunk *Chunk) (int64, error) {
+	loader, err := l.resolve(resource)
+	if err != nil {
+		return 0, err
+	}
+	return loader.DownloadChunk(ctx, resource, path, chunk)
+}
+
 func (l *resourceLoader) Upload(ctx context.Context, resource *Resource, path string, metadata map[string]*string, remove bool) (int64, error) {
 	loader, err := l.resolve(resource)
 	if err != nil {
@@ -104,6 +116,10 @@ func (*phonyResourceLoader) Download(ctx context.Context, resource *Resource, pa
 	return 0, nil
 }
 
+func (*phonyResourceLoader) DownloadChunk(ctx context.Context, resource *Resource, path string, chunk *Chunk) (int64, error) {
+	return 0, nil
+}
+
 func (*phonyResourceLoader) Upload(ctx context.Context, resource *Resource, path string, metadata map[string]*string, remove bool) (int64, error) {
 	return 0, nil
 }
@@ -119,10 +135,12 @@ func (l *phonyResourceLoader) Tag(resource *Resource, tags map[string]string) er
 type s3ResourceLoader struct {
 	svc             *s3.S3
 	defaultMetadata map[string]*string
+	logger          log.Logger
 }
 
-func NewS3ResourceLoader(svc *s3.S3, defaultMetadata map[string]*string) ResourceLoader {
-	return &s3ResourceLoader{svc, defaultMetadata}
+// Creates a ResourceLoader for s3 resources. The logger may be nil.
+func NewS3ResourceLoader(svc *s3.S3, defaultMetadata map[string]*string, logger log.Logger) ResourceLoader {
+	return &s3ResourceLoader{svc, defaultMetadata, loggerOrNop(logger)}
 }
 
 func (l *s3ResourceLoader) Scheme() string {
@@ -131,7 +149,7 @@ func (l *s3ResourceLoader) Scheme() string {
 
 // Returns true if there is enough space to download the object, false otherwise.
 func (l *s3ResourceLoader) CheckCapacityPosix(resource *Resource, path string, scaleFactor uint64) (bool, error) {
-	bucket := aws.NewS3Bucket(l.svc, resource.Url.Host)
+	bucket := aws.NewS3Bucket(l.svc, resource.Url.Host, l.logger)
 	objectSz, err := bucket.Size(resource.Url.Path)
 	if err != nil {
 		return false, err
@@ -153,12 +171,12 @@ func (l *s3ResourceLoader) Download(ctx context.Context, resource *Resource, fil
 		return 0, err
 	}
 
-	bucket := aws.NewS3Bucket(l.svc, resource.Url.Host)
+	bucket := aws.NewS3Bucket(l.svc, resource.Url.Host, l.logger)
 
 	// If the resource is a prefix and the download location is a directory, sync the prefix into the directory.
 	if isPfx && isDir {
-		// Sync
-		return bucket.DownloadPrefix(ctx, resource.Url.Path, filePath, 1)
+		// Sync. Nested prefixes (e.g. the chunk-<index>/ outputs of a fan-out) keep their layout.
+		return bucket.DownloadPrefix(ctx, resource.Url.Path, filePath, maxPrefixDownloadDepth)
 	}
 
 	// If the resource is an object and the download location is a file, use the existing file.
@@ -194,8 +212,42 @@ func (l *s3ResourceLoader) Download(ctx context.Context, resource *Resource, fil
 	panic("unreachable")
 }
 
+func (l *s3ResourceLoader) DownloadChunk(ctx context.Context, resource *Resource, filePath string, chunk *Chunk) (int64, error) {
+	_, object := path.Split(resource.Url.Path)
+	if object == "" {
+		return 0, errors.New("chunked download of a prefix not supported")
+	}
+	isDir, err := isDirectory(filePath)
+	if err != nil {
+		return 0, err
+	}
+	if isDir {
+		filePath = filepath.Join(filePath, object)
+	}
+
+	bucket := aws.NewS3Bucket(l.svc, resource.Url.Host, l.logger)
+	size, err := bucket.Size(resource.Url.Path)
+	if err != nil {
+		return 0, err
+	}
+	first, last := chunk.ByteRange(size)
+	if last < first {
+		return 0, fmt.Errorf("%s is empty for object of %d bytes", chunk.String(), size)
+	}
+
+	f, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return bucket.DownloadRange(ctx, resource.Url.Path, f, first, last)
+}
+
 const MetadataObjectName = "tw-metadata"
 
+// How many levels of a prefix resource are downloaded.
+const maxPrefixDownloadDepth = 8
+
 func (l *s3ResourceLoader) Upload(ctx context.Context, resource *Resource, filePath string, metadata map[string]*string, remove bool) (int64, error) {
 	_, object := path.Split(resource.Url.Path)
 	isPfx := object == ""
@@ -204,12 +256,13 @@ func (l *s3ResourceLoader) Upload(ctx context.Context, resource *Resource, fileP
 		return 0, err
 	}
 
-	bucket := aws.NewS3Bucket(l.svc, resource.Url.Host)
+	bucket := aws.NewS3Bucket(l.svc, resource.Url.Host, l.logger)
 	// bucket.DefaultMetadata(l.defaultMetadata)
+	level.Debug(l.logger).Log("msg", "uploading resource", "bucket", resource.Url.Host, "key", resource.Url.Path,
+		"path", filePath, "prefix", isPfx, "dir", isDir)
 
 	// If the resource is a prefix and the upload location is a directory, sync the directory into the prefix.
 	if isPfx && isDir {
-		fmt.Println("resource is a prefix and upload location a directory")
 		trimmed := trimTrailingSlash(resource.Url.Path)
 		if remove {
 			if err := bucket.DeletePrefix(&aws.ListObjectsOptions{RootPrefix: trimmed}); err != nil {
@@ -232,7 +285,6 @@ func (l *s3ResourceLoader) Upload(ctx context.Context, resource *Resource, fileP
 
 	// If the resource is an object and the upload location is a file, use the object name.
 	if !(isPfx || isDir) {
-		fmt.Println("resource is an object and upload location a file")
 		if remove {
 			if err := bucket.DeleteIfExists(resource.Url.Path); err != nil {
 				return 0, err
@@ -251,7 +303,6 @@ func (l *s3ResourceLoader) Upload(ctx context.Context, resource *Resource, fileP
 	// If the resource is a prefix and the upload location is a file, upload a new object with the filename into the
 	// prefix.
 	if isPfx && !isDir {
-		fmt.Println("resource is a prefix and upload location a file")
 		_, filename := filepath.Split(filePath)
 		key := path.Join(resource.Url.Path, filename)
 
@@ -273,7 +324,6 @@ func (l *s3ResourceLoader) Upload(ctx context.Context, resource *Resource, fileP
 	// If the resource is an object and the upload location is a directory, archive the upload directory into the
 	// object.
 	if !isPfx && isDir {
-		fmt.Println("resource is an object and upload location a directory")
 		ext := filepath.Ext(object)
 		if ext != ".tar" {
 			return 0, fmt.Errorf("unsupported archive format %s", ext)
@@ -286,7 +336,7 @@ func (l *s3ResourceLoader) Upload(ctx context.Context, resource *Resource, fileP
 }
 
 func (l *s3ResourceLoader) Exists(_ context.Context, resource *Resource) (bool, error) {
-	return aws.NewS3Bucket(l.svc, resource.Url.Host).Exists(resource.Url.Path)
+	return aws.NewS3Bucket(l.svc, resource.Url.Host, l.logger).Exists(resource.Url.Path)
 }
 
 func (l *s3ResourceLoader) Tag(resource *Resource, tags map[string]string) error {
@@ -0,0 +1,11 @@
+package core
+
+import "github.com/go-kit/kit/log"
+
+// Returns logger, or a logger that discards the log if it is nil.
+func loggerOrNop(logger log.Logger) log.Logger {
+	if logger == nil {
+		return log.NewNopLogger()
+	}
+	return logger
+}
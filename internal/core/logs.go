@@ -0,0 +1,177 @@
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Maximum number of bytes of each output stream kept from an execution.
+const MaxLogSize = 16 << 20
+
+// Number of trailing bytes of stderr included in the error of a failed execution.
+const stderrTailSize = 2 << 10
+
+// Metadata names of captured output streams.
+const (
+	StdoutLogName = "stdout.log"
+	StderrLogName = "stderr.log"
+)
+
+// ExitError is the ExecutionResult error of an execution that exited with a non-zero status.
+type ExitError struct {
+	Code   int
+	Stderr string
+}
+
+func (e *ExitError) Error() string {
+	msg := fmt.Sprintf("non-zero exit status %d", e.Code)
+	if e.Stderr != "" {
+		msg += ", stderr:\n" + e.Stderr
+	}
+	return msg
+}
+
+// Output streams of an execution written to a Task's internal directory.
+type taskLogs struct {
+	stdout     *os.File
+	stderr     *os.File
+	stdoutCap  *cappedWriter
+	stderrCap  *cappedWriter
+	stderrTail *tailBuffer
+}
+
+func createTaskLogs(task *Task) (*taskLogs, error) {
+	stdout, err := os.OpenFile(filepath.Join(task.Workspace.InternalDir, StdoutLogName), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := os.OpenFile(filepath.Join(task.Workspace.InternalDir, StderrLogName), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		stdout.Close()
+		return nil, err
+	}
+	return &taskLogs{
+		stdout:     stdout,
+		stderr:     stderr,
+		stdoutCap:  &cappedWriter{w: stdout, remaining: MaxLogSize},
+		stderrCap:  &cappedWriter{w: stderr, remaining: MaxLogSize},
+		stderrTail: &tailBuffer{size: stderrTailSize},
+	}, nil
+}
+
+func (l *taskLogs) Stdout() io.Writer {
+	return l.stdoutCap
+}
+
+func (l *taskLogs) Stderr() io.Writer {
+	return io.MultiWriter(l.stderrCap, l.stderrTail)
+}
+
+// Tail of stderr, trimmed of surrounding whitespace.
+func (l *taskLogs) StderrTail() string {
+	return strings.TrimSpace(l.stderrTail.String())
+}
+
+// Metadata names and paths of the log files.
+func (l *taskLogs) Paths() map[string]string {
+	return map[string]string{
+		StdoutLogName: l.stdout.Name(),
+		StderrLogName: l.stderr.Name(),
+	}
+}
+
+func (l *taskLogs) Close() error {
+	for _, w := range []*cappedWriter{l.stdoutCap, l.stderrCap} {
+		if w.truncated {
+			_, _ = fmt.Fprintf(w.w, "\n[chyme: output truncated at %d bytes]\n", MaxLogSize)
+		}
+	}
+	errOut := l.stdout.Close()
+	if err := l.stderr.Close(); err != nil {
+		return err
+	}
+	return errOut
+}
+
+// cappedWriter writes up to a fixed number of bytes to w and silently discards the rest, so a chatty process cannot
+// fill the disk or fail because its logs were cut off.
+type cappedWriter struct {
+	w         io.Writer
+	remaining int64
+	truncated bool
+}
+
+func (c *cappedWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+		c.truncated = true
+	}
+	if len(p) > 0 {
+		written, err := c.w.Write(p)
+		c.remaining -= int64(written)
+		if err != nil {
+			return written, err
+		}
+	}
+	return n, nil
+}
+
+// tailBuffer keeps the last size bytes written to it.
+type tailBuffer struct {
+	size int
+	buf  []byte
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	if over := len(t.buf) - t.size; over > 0 {
+		t.buf = append(t.buf[:0], t.buf[over:]...)
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string {
+	return string(t.buf)
+}
+
+// Stream identifiers of the Docker multiplexed log format.
+const (
+	streamStdin  = 0
+	streamStdout = 1
+	streamStderr = 2
+)
+
+// Splits a Docker multiplexed stream (as returned for containers without a TTY) into stdout and stderr. Each frame
+// is an 8 byte header holding the stream identifier and the big-endian payload length, followed by the payload.
+func demuxLogs(r io.Reader, stdout io.Writer, stderr io.Writer) error {
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		var w io.Writer
+		switch header[0] {
+		case streamStdin, streamStdout:
+			w = stdout
+		case streamStderr:
+			w = stderr
+		default:
+			return errors.New("unrecognized log stream identifier")
+		}
+
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+		if _, err := io.CopyN(w, r, size); err != nil {
+			return err
+		}
+	}
+}
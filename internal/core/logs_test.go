@@ -0,0 +1,139 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+)
+
+func frame(stream byte, payload string) []byte {
+	header := make([]byte, 8)
+	header[0] = stream
+	binary.BigEndian.PutUint32(header[4:], uint32(len(payload)))
+	return append(header, payload...)
+}
+
+func frames(frames ...[]byte) []byte {
+	return bytes.Join(frames, nil)
+}
+
+func TestDemuxLogs(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  []byte
+		stdout string
+		stderr string
+		err    string
+	}{
+		{name: "empty"},
+		{
+			name:   "interleaved",
+			input:  frames(frame(streamStdout, "out1 "), frame(streamStderr, "err"), frame(streamStdout, "out2")),
+			stdout: "out1 out2",
+			stderr: "err",
+		},
+		{name: "stdin as stdout", input: frame(streamStdin, "in"), stdout: "in"},
+		{name: "empty payload", input: frames(frame(streamStderr, ""), frame(streamStderr, "err")), stderr: "err"},
+		{
+			name:   "partial header",
+			input:  append(frame(streamStdout, "out"), streamStderr, 0, 0),
+			stdout: "out",
+			err:    "unexpected EOF",
+		},
+		{name: "short payload", input: frame(streamStdout, "out")[:10], stdout: "ou", err: "EOF"},
+		{name: "unknown stream", input: frame(3, "x"), err: "unrecognized log stream identifier"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			err := demuxLogs(bytes.NewReader(tt.input), &stdout, &stderr)
+			if err != nil && err.Error() != tt.err || err == nil && tt.err != "" {
+				t.Errorf("error = %v, want %q", err, tt.err)
+			}
+			if stdout.String() != tt.stdout {
+				t.Errorf("stdout = %q, want %q", stdout.String(), tt.stdout)
+			}
+			if stderr.String() != tt.stderr {
+				t.Errorf("stderr = %q, want %q", stderr.String(), tt.stderr)
+			}
+		})
+	}
+}
+
+func TestCappedWriter(t *testing.T) {
+	tests := []struct {
+		name      string
+		cap       int64
+		writes    []string
+		want      string
+		truncated bool
+	}{
+		{name: "under cap", cap: 10, writes: []string{"abc", "def"}, want: "abcdef"},
+		{name: "exactly cap", cap: 6, writes: []string{"abc", "def"}, want: "abcdef"},
+		{name: "cap mid write", cap: 4, writes: []string{"abc", "def"}, want: "abcd", truncated: true},
+		{name: "writes after cap", cap: 3, writes: []string{"abc", "def", "ghi"}, want: "abc", truncated: true},
+		{name: "zero cap", cap: 0, writes: []string{"abc"}, want: "", truncated: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := &cappedWriter{w: &buf, remaining: tt.cap}
+			for _, s := range tt.writes {
+				if n, err := w.Write([]byte(s)); n != len(s) || err != nil {
+					t.Fatalf("Write(%q) = %d, %v, want %d, nil", s, n, err, len(s))
+				}
+			}
+			if buf.String() != tt.want {
+				t.Errorf("written %q, want %q", buf.String(), tt.want)
+			}
+			if w.truncated != tt.truncated {
+				t.Errorf("truncated = %t, want %t", w.truncated, tt.truncated)
+			}
+		})
+	}
+}
+
+func TestCappedWriterMidFrame(t *testing.T) {
+	var buf bytes.Buffer
+	stdout := &cappedWriter{w: &buf, remaining: 6}
+	input := frames(frame(streamStdout, "abcd"), frame(streamStdout, "efgh"), frame(streamStdout, "ijkl"))
+
+	if err := demuxLogs(bytes.NewReader(input), stdout, ioutil.Discard); err != nil {
+		t.Fatalf("demuxLogs() = %v, want nil", err)
+	}
+	if buf.String() != "abcdef" || !stdout.truncated {
+		t.Errorf("written %q, truncated %t, want %q, true", buf.String(), stdout.truncated, "abcdef")
+	}
+}
+
+func TestTailBuffer(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   int
+		writes []string
+		want   string
+	}{
+		{name: "under size", size: 10, writes: []string{"abc", "def"}, want: "abcdef"},
+		{name: "exactly size", size: 6, writes: []string{"abc", "def"}, want: "abcdef"},
+		{name: "over size", size: 4, writes: []string{"abc", "def"}, want: "cdef"},
+		{name: "size smaller than a write", size: 2, writes: []string{"abcdef"}, want: "ef"},
+		{name: "size smaller than each write", size: 2, writes: []string{"abc", "def", "ghi"}, want: "hi"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &tailBuffer{size: tt.size}
+			for _, s := range tt.writes {
+				if n, err := b.Write([]byte(s)); n != len(s) || err != nil {
+					t.Fatalf("Write(%q) = %d, %v, want %d, nil", s, n, err, len(s))
+				}
+			}
+			if b.String() != tt.want {
+				t.Errorf("String() = %q, want %q", b.String(), tt.want)
+			}
+		})
+	}
+}
@@ -3,22 +3,43 @@ package core
 import (
 	"crypto/sha1"
 	"encoding/hex"
+	"encoding/json"
 	"os/exec"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 	"io"
-	"fmt"
 	"github.com/go-redis/redis"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"kroekerlabs.dev/chyme/services/pkg/tracing"
 )
 
 type Resource struct {
 	Url   *url.URL `json:"url"`
 	// Phony indicates whether or not resource should be downloaded/uploaded during processing
 	Phony bool `json:"phony"`
+	// Trace context of the ingest that added the Resource to its set, kept next to the set rather than in it.
+	TraceContext tracing.Carrier `json:"-"`
+	// Metadata of the object listed by the ingest, if it was listed. Kept next to the set like the trace context.
+	Metadata *ResourceMetadata `json:"-"`
 	hash  string
 }
 
+// Metadata of the object of a Resource, as listed in its bucket.
+type ResourceMetadata struct {
+	Size         int64     `json:"size"`
+	Modified     time.Time `json:"modified"`
+	ETag         string    `json:"etag,omitempty"`
+	StorageClass string    `json:"storageClass,omitempty"`
+}
+
+func (m *ResourceMetadata) encode() string {
+	b, _ := json.Marshal(m)
+	return string(b)
+}
+
 func (r *Resource) String() string {
 	return r.Url.String()
 }
@@ -36,6 +57,8 @@ func (r *Resource) Hash() string {
 type ResourceRepository interface {
 	Pop(setKey string, count int) ([]*Resource, error)
 	Add(setKey string, resources ...*Resource) (int, error)
+	// Removes the Resources from the set at key `setKey`, returning how many of them it held.
+	Remove(setKey string, resources ...*Resource) (int, error)
 	BulkInsert(setKey string) (BulkResourceInserter, error)
 	Count(setKey string) (int, error)
 }
@@ -43,33 +66,47 @@ type ResourceRepository interface {
 // Concrete redis implementation of ResourceRepository.
 type redisResourceRepository struct {
 	client *redis.Client
+	logger log.Logger
 }
 
-func NewRedisResourceRepository(client *redis.Client) ResourceRepository {
-	return &redisResourceRepository{client}
+// Creates a ResourceRepository of redis sets. The logger may be nil.
+func NewRedisResourceRepository(client *redis.Client, logger log.Logger) ResourceRepository {
+	return &redisResourceRepository{client, loggerOrNop(logger)}
 }
 
 // Pops max `count` Resources from the Set at key `setKey`. If the set contains less than `count`, the number of
 // Resources returned will equal the size of the set.
 func (r *redisResourceRepository) Pop(setKey string, count int) ([]*Resource, error) {
-	fmt.Println("setKey")
-	fmt.Println(setKey)
-	fmt.Println("count")
-	fmt.Println(count)
 	elements, err := r.client.SPopN(setKey, int64(count)).Result()
 	if err != nil {
 		return nil, err
 	}
+	traceContexts := r.popValues(traceContextKey(setKey), "trace context", setKey, elements)
+	metadata := r.popValues(metadataKey(setKey), "metadata", setKey, elements)
 
 	resources := make([]*Resource, 0)
-	for _, el := range elements {
+	for i, el := range elements {
 		resourceUrl, err := url.Parse(el)
 		if err != nil {
 			// TODO: Move bad URL to reject set.
+			level.Warn(r.logger).Log("msg", "dropping invalid resource URL", "set", setKey, "url", el, "err", err)
 			continue
 		}
-		resources = append(resources, &Resource{Url: resourceUrl})
+		resource := &Resource{Url: resourceUrl}
+		if traceContexts[i] != "" {
+			resource.TraceContext = tracing.Decode(traceContexts[i])
+		}
+		if metadata[i] != "" {
+			resource.Metadata = new(ResourceMetadata)
+			if err := json.Unmarshal([]byte(metadata[i]), resource.Metadata); err != nil {
+				level.Warn(r.logger).Log("msg", "dropping invalid metadata of resource", "set", setKey, "url", el,
+					"err", err)
+				resource.Metadata = nil
+			}
+		}
+		resources = append(resources, resource)
 	}
+	level.Debug(r.logger).Log("msg", "popped resources", "set", setKey, "requested", count, "count", len(resources))
 
 	return resources, nil
 }
@@ -78,14 +115,81 @@ func (r *redisResourceRepository) Add(setKey string, resources ...*Resource) (in
 	nResources := len(resources)
 	urls := make([]interface{}, nResources)
 	for i, resource := range resources {
-		fmt.Println(resource)
 		urls[i] = resource.String()
 	}
 
 	count, err := r.client.SAdd(setKey, urls...).Result()
+	if err != nil {
+		return 0, err
+	}
+	level.Debug(r.logger).Log("msg", "added resources", "set", setKey, "count", count)
+
+	traceContexts := make(map[string]interface{})
+	metadata := make(map[string]interface{})
+	for _, resource := range resources {
+		if len(resource.TraceContext) > 0 {
+			traceContexts[resource.String()] = resource.TraceContext.Encode()
+		}
+		if resource.Metadata != nil {
+			metadata[resource.String()] = resource.Metadata.encode()
+		}
+	}
+	if len(traceContexts) > 0 {
+		if err := r.client.HMSet(traceContextKey(setKey), traceContexts).Err(); err != nil {
+			level.Warn(r.logger).Log("msg", "failed to store trace context of resources", "set", setKey, "err", err)
+		}
+	}
+	if len(metadata) > 0 {
+		if err := r.client.HMSet(metadataKey(setKey), metadata).Err(); err != nil {
+			level.Warn(r.logger).Log("msg", "failed to store metadata of resources", "set", setKey, "err", err)
+		}
+	}
+	return int(count), nil
+}
+
+func (r *redisResourceRepository) Remove(setKey string, resources ...*Resource) (int, error) {
+	urls := make([]interface{}, len(resources))
+	for i, resource := range resources {
+		urls[i] = resource.String()
+	}
+	count, err := r.client.SRem(setKey, urls...).Result()
 	return int(count), err
 }
 
+// Removes and returns the values held for the popped elements of a set in the hash at key, e.g. their trace contexts,
+// with "" for those without one. A Resource without a trace context starts a new trace, and one without metadata
+// lacks it in output patterns, so failing to read them does not fail the pop.
+func (r *redisResourceRepository) popValues(key, what, setKey string, elements []string) []string {
+	strs := make([]string, len(elements))
+	if len(elements) == 0 {
+		return strs
+	}
+	values, err := r.client.HMGet(key, elements...).Result()
+	if err != nil {
+		level.Warn(r.logger).Log("msg", "failed to read "+what+" of resources", "set", setKey, "err", err)
+		return strs
+	}
+	for i, value := range values {
+		if s, ok := value.(string); ok {
+			strs[i] = s
+		}
+	}
+	if err := r.client.HDel(key, elements...).Err(); err != nil {
+		level.Warn(r.logger).Log("msg", "failed to remove "+what+" of resources", "set", setKey, "err", err)
+	}
+	return strs
+}
+
+// Key of the hash that holds the trace contexts of the Resources of a set by their URL.
+func traceContextKey(setKey string) string {
+	return setKey + ":trace"
+}
+
+// Key of the hash that holds the metadata of the Resources of a set by their URL.
+func metadataKey(setKey string) string {
+	return setKey + ":meta"
+}
+
 func (r *redisResourceRepository) BulkInsert(setKey string) (BulkResourceInserter, error) {
 	cmd := exec.Command("redis-cli", "--pipe")
 
@@ -122,9 +226,15 @@ type redisBulkInserter struct {
 
 func (i *redisBulkInserter) Insert(resource *Resource) (error) {
 	// _, err := io.WriteString(i.wc, Encode([]string{"SADD", i.setKey, resource}))
-	_, err := i.wc.Write([]byte(Encode([]string{"SADD", i.setKey, resource.String()})))
+	cmds := Encode([]string{"SADD", i.setKey, resource.String()})
+	if len(resource.TraceContext) > 0 {
+		cmds += Encode([]string{"HSET", traceContextKey(i.setKey), resource.String(), resource.TraceContext.Encode()})
+	}
+	if resource.Metadata != nil {
+		cmds += Encode([]string{"HSET", metadataKey(i.setKey), resource.String(), resource.Metadata.encode()})
+	}
+	_, err := i.wc.Write([]byte(cmds))
 	if err != nil {
-		fmt.Println("bulk insert failed")
 		return err
 	}
 	return nil
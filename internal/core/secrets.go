@@ -0,0 +1,189 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+	"kroekerlabs.dev/chyme/services/pkg/vault"
+)
+
+// Container path of the directory secrets delivered as files are mounted at.
+const SecretsMountPath = "/run/secrets"
+
+// Host directory the files of secrets are written to unless configured otherwise. It is a tmpfs on Linux, so
+// secrets never reach the disk.
+const DefaultSecretsDir = "/dev/shm/chyme-secrets"
+
+// A secret of an ExecutionStrategy, resolved by the worker when the Task's container is created. Only the reference
+// is part of the Task, so the secret's value is not in the queue message, the persisted state or the logs.
+type Secret struct {
+	// Vault path of the secret, e.g. secret/data/chyme/tasks/transcoder, and the field of it to deliver.
+	Path  string `json:"path" yaml:"path"`
+	Field string `json:"field" yaml:"field"`
+	// Environment variable the value is delivered as.
+	Env string `json:"env,omitempty" yaml:"env"`
+	// Name of the file under SecretsMountPath the value is delivered as.
+	File string `json:"file,omitempty" yaml:"file"`
+}
+
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func (s Secret) Validate() error {
+	if s.Path == "" || s.Field == "" {
+		return fmt.Errorf("secret %s: path and field are required", s.Path)
+	}
+	if (s.Env == "") == (s.File == "") {
+		return fmt.Errorf("secret %s: exactly one of env and file is required", s.Path)
+	}
+	if s.Env != "" && !envNamePattern.MatchString(s.Env) {
+		return fmt.Errorf("secret %s: invalid env name %s", s.Path, s.Env)
+	}
+	if s.File != "" && (path.IsAbs(s.File) || strings.ContainsRune(s.File, '/') || s.File == "." || s.File == "..") {
+		return fmt.Errorf("secret %s: file %s must be a plain file name", s.Path, s.File)
+	}
+	return nil
+}
+
+// Resolves the secrets of ExecutionStrategies.
+type SecretResolver interface {
+	Resolve(path string, field string) (string, error)
+}
+
+type vaultSecretResolver struct {
+	client *vault.Client
+	prefix string
+}
+
+// Creates a SecretResolver that reads secrets from Vault. Only paths under prefix may be read, so that templates
+// cannot reach the worker's other secrets.
+func NewVaultSecretResolver(client *vault.Client, prefix string) SecretResolver {
+	return &vaultSecretResolver{client, strings.TrimRight(prefix, "/")}
+}
+
+func (r *vaultSecretResolver) Resolve(secretPath string, field string) (string, error) {
+	p := path.Clean(secretPath)
+	if !strings.HasPrefix(p, r.prefix+"/") {
+		return "", fmt.Errorf("secret %s is not under %s", secretPath, r.prefix)
+	}
+	return r.client.ReadSecretField(p, field)
+}
+
+// Secrets of a Task resolved for its container.
+type taskSecrets struct {
+	// KEY=value pairs of the secrets delivered as environment variables.
+	Env []string
+	// Host directory of the secrets delivered as files, "" if there are none.
+	Dir string
+}
+
+// Resolves the Task's secrets, writing those delivered as files to a directory of secretsDir named by the Task's
+// hash.
+func resolveSecrets(task *Task, resolver SecretResolver, secretsDir string) (*taskSecrets, error) {
+	secrets := task.ExecutionStrategy.Config.Secrets
+	resolved := &taskSecrets{}
+	if len(secrets) == 0 {
+		return resolved, nil
+	}
+	if resolver == nil {
+		return nil, errors.New("invalid configuration: this worker has no secrets configured")
+	}
+
+	files := make(map[string]string)
+	for _, secret := range secrets {
+		value, err := resolver.Resolve(secret.Path, secret.Field)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve secret %s: %s", secret.Path, err.Error())
+		}
+		if secret.Env != "" {
+			resolved.Env = append(resolved.Env, secret.Env+"="+value)
+		} else {
+			files[secret.File] = value
+		}
+	}
+	if len(files) == 0 {
+		return resolved, nil
+	}
+
+	// Other host users cannot enter secretsDir; the Task's directory is readable so that the container's user,
+	// which need not be the worker's, can read the bind-mounted files.
+	if err := os.MkdirAll(secretsDirOrDefault(secretsDir), 0700); err != nil {
+		return nil, err
+	}
+	resolved.Dir = taskSecretsDir(task, secretsDir)
+	if err := os.MkdirAll(resolved.Dir, 0755); err != nil {
+		return nil, err
+	}
+	for name, value := range files {
+		if err := ioutil.WriteFile(filepath.Join(resolved.Dir, name), []byte(value), 0444); err != nil {
+			removeSecrets(task, secretsDir)
+			return nil, fmt.Errorf("failed to write secret file %s: %s", name, err.Error())
+		}
+	}
+	return resolved, nil
+}
+
+// Removes the files of the Task's secrets, if any were written.
+func removeSecrets(task *Task, secretsDir string) error {
+	return removeSecretsOf(task.Hash(), secretsDir)
+}
+
+// Removes the files of the secrets of the Task with the hash, if any were written.
+func removeSecretsOf(taskHash string, secretsDir string) error {
+	return os.RemoveAll(filepath.Join(secretsDirOrDefault(secretsDir), taskHash))
+}
+
+func taskSecretsDir(task *Task, secretsDir string) string {
+	return filepath.Join(secretsDirOrDefault(secretsDir), task.Hash())
+}
+
+// Implemented by TaskExecutors that write the secret files of Tasks, so that a restarted worker can remove those left
+// by Tasks it no longer has, e.g. of a worker that was killed.
+type SecretSweeper interface {
+	// Removes the secret files of every Task except those whose hashes are kept.
+	SweepSecrets(keep map[string]bool) error
+}
+
+// Names of the directories of Tasks in the secrets directory: their hashes.
+var taskHashPattern = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+func sweepSecrets(secretsDir string, keep map[string]bool) error {
+	dir := secretsDirOrDefault(secretsDir)
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	errs := &multierror.Error{}
+	for _, entry := range entries {
+		// Only the directories of Tasks are swept, not e.g. the auth files of image pulls in progress.
+		if !entry.IsDir() || !taskHashPattern.MatchString(entry.Name()) || keep[entry.Name()] {
+			continue
+		}
+		errs = multierror.Append(errs, os.RemoveAll(filepath.Join(dir, entry.Name())))
+	}
+	return errs.ErrorOrNil()
+}
+
+func secretsDirOrDefault(secretsDir string) string {
+	if secretsDir != "" {
+		return secretsDir
+	}
+	return DefaultSecretsDir
+}
+
+// Bind of the secrets directory, "" if there are no secret files.
+func (s *taskSecrets) bind() string {
+	if s.Dir == "" {
+		return ""
+	}
+	return s.Dir + ":" + SecretsMountPath + ":ro"
+}
@@ -12,11 +12,32 @@ import (
 
 	"kroekerlabs.dev/chyme/services/pkg/hash"
 	"kroekerlabs.dev/chyme/services/pkg/aws"
+	"kroekerlabs.dev/chyme/services/pkg/tracing"
 	"github.com/go-redis/redis"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
 	amzaws "github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/sqs"
 )
 
+// Names of the hooks every worker registers, which Tasks select by their Hooks field. Tasks without hooks run none.
+const (
+	HooksNone = ""
+	HooksMOV  = "mov"
+	HooksMP4  = "mp4"
+)
+
+// Reports whether workers register hooks of the name.
+func KnownHooks(name string) bool {
+	switch name {
+	case HooksNone, HooksMOV, HooksMP4:
+		return true
+	}
+	return false
+}
+
 // Represents a processing task.
 type Task struct {
 	sync.Mutex
@@ -29,6 +50,20 @@ type Task struct {
 	Workspace         *TaskWorkspace     `json:"workspace"`
 	Timeout           time.Duration      `json:"timeout"`
 	Version           string             `json:"version"`
+	// Name of the Template that created the Task.
+	Template          string             `json:"template,omitempty"`
+	// Reference by digest to the image the Task was executed with, set by the executor.
+	ImageDigest       string             `json:"imageDigest,omitempty"`
+	// Hash of the first Task of the chain this Task belongs to, empty for the first Task itself.
+	ParentID          string             `json:"parentId,omitempty"`
+	// Tasks to enqueue when this Task completes successfully. Their InputResource is this Task's OutputResource.
+	Next              []*Task            `json:"next,omitempty"`
+	// Set on the Tasks of a fan-out. Join is enqueued once every chunk in the group has completed.
+	Chunk             *Chunk             `json:"chunk,omitempty"`
+	Join              *Task              `json:"join,omitempty"`
+	// Trace context of the span that enqueued the Task. It travels in the attributes of the Task's queue message
+	// rather than its body.
+	TraceContext      tracing.Carrier    `json:"-"`
 
 	isDeleted bool 
 	hash      string
@@ -49,6 +84,14 @@ func (t *Task) Hash() string {
 	return t.hash
 }
 
+// ID of the chain the Task belongs to: the hash of the chain's first Task.
+func (t *Task) ChainID() string {
+	if t.ParentID != "" {
+		return t.ParentID
+	}
+	return t.Hash()
+}
+
 /*
  *  TASK QUEUE
  */
@@ -59,6 +102,7 @@ type TaskQueue interface {
 	Delete(message *TaskMessage) error 
 	Fail(message *TaskMessage, err error) error
 	MessageCount() (int, error)
+	MessageCountWithContext(ctx context.Context) (int, error)
 }
 
 type TaskMessage struct {
@@ -83,7 +127,30 @@ func NewSQSTaskQueue(backingQueue *aws.SqsQueue, deadLetterQueue *aws.SqsQueue)
 
 // Enqueues a Task.
 func (q *sqsTaskQueue) Enqueue(task *Task) error {
-	return q.sqsQueue.Enqueue(task)
+	return q.sqsQueue.EnqueueWithAttrs(task, traceAttributes(task.TraceContext))
+}
+
+// Message attributes carrying a trace context.
+func traceAttributes(carrier tracing.Carrier) map[string]*sqs.MessageAttributeValue {
+	attrs := make(map[string]*sqs.MessageAttributeValue)
+	for k, v := range carrier {
+		attrs[k] = &sqs.MessageAttributeValue{
+			DataType:    amzaws.String("String"),
+			StringValue: amzaws.String(v),
+		}
+	}
+	return attrs
+}
+
+// Trace context carried by the attributes of a message.
+func traceContextOf(message *sqs.Message) tracing.Carrier {
+	carrier := tracing.Carrier{}
+	for _, field := range tracing.Fields() {
+		if attr, ok := message.MessageAttributes[field]; ok && attr.StringValue != nil {
+			carrier[field] = *attr.StringValue
+		}
+	}
+	return carrier
 }
 
 // Dequeues tasks.
@@ -99,6 +166,7 @@ func (q *sqsTaskQueue) Dequeue(max int) ([]*TaskMessage, error) {
 		if err := json.Unmarshal([]byte(*message.Body), &task); err != nil {
 			continue
 		}
+		task.TraceContext = traceContextOf(message)
 
 		taskMessage := &TaskMessage{
 			Task:          &task,
@@ -134,22 +202,26 @@ func (q *sqsTaskQueue) Fail(message *TaskMessage, err error) error {
 		return err
 	}
 
-	return q.deadLetterQueue.EnqueueWithAttrs(message.Task, map[string]*sqs.MessageAttributeValue{
-		"Error": {
-			DataType:    amzaws.String("String"),
-			StringValue: amzaws.String(err.Error()),
-		},
-		"Hash": {
-			DataType:    amzaws.String("String"),
-			StringValue: amzaws.String(message.Task.Hash()),
-		},
-	})
+	attrs := traceAttributes(message.Task.TraceContext)
+	attrs["Error"] = &sqs.MessageAttributeValue{
+		DataType:    amzaws.String("String"),
+		StringValue: amzaws.String(err.Error()),
+	}
+	attrs["Hash"] = &sqs.MessageAttributeValue{
+		DataType:    amzaws.String("String"),
+		StringValue: amzaws.String(message.Task.Hash()),
+	}
+	return q.deadLetterQueue.EnqueueWithAttrs(message.Task, attrs)
 }
 
 func (q *sqsTaskQueue) MessageCount() (int, error) {
 	return q.sqsQueue.MessageCount()
 }
 
+func (q *sqsTaskQueue) MessageCountWithContext(ctx context.Context) (int, error) {
+	return q.sqsQueue.MessageCountWithContext(ctx)
+}
+
 /*
  * TASK REPOSITORY
  */
@@ -157,6 +229,25 @@ func (q *sqsTaskQueue) MessageCount() (int, error) {
 type TaskRepository interface {
 	Add(task *Task) error
 	Remove(task *Task) error
+	// Reports whether the Task was added.
+	Has(task *Task) (bool, error)
+	// Records the Task as a step of the chain identified by its ChainID.
+	AddToChain(task *Task) error
+	// Records the ImageDigest of a Task that ran in a container, so that what ran is known after its message is gone.
+	RecordImage(task *Task) error
+	// Returns the hashes of the Tasks recorded in the chain with ID chainID.
+	Chain(chainID string) ([]string, error)
+	// Records the completion of a chunk Task. Returns true for the one call that completes the chunk's group, which
+	// claims the enqueue of the group's join Task: the caller must then call JoinEnqueued, or ReleaseJoin if it could
+	// not enqueue the join Task, so that a retry of any of the group's chunks claims it again.
+	CompleteChunk(task *Task) (bool, error)
+	JoinEnqueued(task *Task) error
+	ReleaseJoin(task *Task) error
+	// Records that the Task itself completed, so that a redelivery of its message whose follow-up Tasks failed to
+	// enqueue only retries the enqueue. Completion is forgotten once the message is done with, by ClearCompleted.
+	SetCompleted(task *Task) error
+	Completed(task *Task) (bool, error)
+	ClearCompleted(task *Task) error
 }
 
 type redisTaskRepository struct {
@@ -178,6 +269,109 @@ func (r *redisTaskRepository) Remove(task *Task) (err error) {
 	return
 }
 
+func (r *redisTaskRepository) Has(task *Task) (bool, error) {
+	return r.client.SIsMember(r.setKey, task.Hash()).Result()
+}
+
+func (r *redisTaskRepository) RecordImage(task *Task) error {
+	return r.client.HSet(r.imagesKey(), task.Hash(), task.ImageDigest).Err()
+}
+
+func (r *redisTaskRepository) AddToChain(task *Task) (err error) {
+	_, err = r.client.SAdd(r.chainKey(task.ChainID()), task.Hash()).Result()
+	return
+}
+
+func (r *redisTaskRepository) Chain(chainID string) ([]string, error) {
+	return r.client.SMembers(r.chainKey(chainID)).Result()
+}
+
+// Completed chunks are tracked as a set of indices per group. Once the set is full, the enqueue of the join Task is
+// claimed with a key of the group that only one completion can set, so repeated completions of a chunk, e.g. of a
+// chunk retried after its join Task failed to enqueue, complete the group at most once at a time.
+func (r *redisTaskRepository) CompleteChunk(task *Task) (bool, error) {
+	if task.Chunk == nil {
+		return false, errors.New("task is not a chunk")
+	}
+	key := r.chunksKey(task)
+
+	var count *redis.IntCmd
+	_, err := r.client.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.SAdd(key, task.Chunk.Index)
+		count = pipe.SCard(key)
+		pipe.Expire(key, chunkGroupTTL)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	if count.Val() < int64(task.Chunk.Count) {
+		return false, nil
+	}
+	return r.client.SetNX(r.joinKey(task), joinClaimed, joinClaimTTL).Result()
+}
+
+// Records that the join Task of the chunk's group was enqueued, so that no completion claims it again.
+func (r *redisTaskRepository) JoinEnqueued(task *Task) error {
+	return r.client.Set(r.joinKey(task), joinEnqueued, chunkGroupTTL).Err()
+}
+
+// Releases the claim of CompleteChunk on the enqueue of the join Task of the chunk's group.
+func (r *redisTaskRepository) ReleaseJoin(task *Task) error {
+	return r.client.Del(r.joinKey(task)).Err()
+}
+
+func (r *redisTaskRepository) SetCompleted(task *Task) error {
+	return r.client.Set(r.completedKey(task), 1, completedTTL).Err()
+}
+
+func (r *redisTaskRepository) Completed(task *Task) (bool, error) {
+	n, err := r.client.Exists(r.completedKey(task)).Result()
+	return n > 0, err
+}
+
+func (r *redisTaskRepository) ClearCompleted(task *Task) error {
+	return r.client.Del(r.completedKey(task)).Err()
+}
+
+func (r *redisTaskRepository) completedKey(task *Task) string {
+	return r.setKey + ":completed:" + task.Hash()
+}
+
+// Key of the hash that holds the image digests of Tasks by their hash.
+func (r *redisTaskRepository) imagesKey() string {
+	return r.setKey + ":images"
+}
+
+func (r *redisTaskRepository) chunksKey(task *Task) string {
+	return r.setKey + ":chunks:" + task.Chunk.Group
+}
+
+func (r *redisTaskRepository) joinKey(task *Task) string {
+	return r.chunksKey(task) + ":join"
+}
+
+// Values of the join key of a chunk group.
+const (
+	joinClaimed  = "claimed"
+	joinEnqueued = "enqueued"
+)
+
+// How long a claim on the enqueue of a join Task holds if the worker that made it never confirms or releases it,
+// e.g. because it crashed. A chunk retried after then claims it again.
+const joinClaimTTL = 10 * time.Minute
+
+// How long chunk completions are kept after the last one is recorded.
+const chunkGroupTTL = time.Hour * 24 * 7
+
+// How long the completion of a Task is kept if its message is never done with, e.g. because it went to the dead
+// letter queue: the longest SQS retains a message.
+const completedTTL = time.Hour * 24 * 14
+
+func (r *redisTaskRepository) chainKey(chainID string) string {
+	return r.setKey + ":chain:" + chainID
+}
+
 /*
  * TASK LOADER
  */
@@ -193,13 +387,25 @@ type TaskLoader interface {
 	Clean(task *Task) error
 }
 
+// Metrics of a TaskLoader. The counters have a template label.
+type LoaderMetrics struct {
+	BytesDownloaded metrics.Counter
+	BytesUploaded   metrics.Counter
+}
+
 type taskLoader struct {
 	loader  ResourceLoader
 	workDir string
+	logger  log.Logger
+	metrics *LoaderMetrics
 }
 
-func NewTaskLoader(loader ResourceLoader, workDir string) TaskLoader {
-	return &taskLoader{loader, workDir}
+// Creates a TaskLoader with workspaces under workDir. The logger and metrics may be nil.
+func NewTaskLoader(loader ResourceLoader, workDir string, logger log.Logger, metrics *LoaderMetrics) TaskLoader {
+	if metrics == nil {
+		metrics = &LoaderMetrics{BytesDownloaded: discard.NewCounter(), BytesUploaded: discard.NewCounter()}
+	}
+	return &taskLoader{loader, workDir, loggerOrNop(logger), metrics}
 }
 
 func (l *taskLoader) CreateWorkspace(task *Task) error {
@@ -232,7 +438,18 @@ func (l *taskLoader) Download(ctx context.Context, task *Task) (err error) {
 	if err := removeContents(task.Workspace.InputDir, 0700); err != nil {
 		return err
 	}
-	_, err = l.loader.Download(ctx, task.InputResource, task.Workspace.InputDir)
+	began := time.Now()
+	var n int64
+	if task.Chunk != nil && task.Chunk.Mode == ChunkBytes {
+		n, err = l.loader.DownloadChunk(ctx, task.InputResource, task.Workspace.InputDir, task.Chunk)
+	} else {
+		n, err = l.loader.Download(ctx, task.InputResource, task.Workspace.InputDir)
+	}
+	l.metrics.BytesDownloaded.With("template", task.Template).Add(float64(n))
+	if err == nil {
+		level.Debug(l.logger).Log("msg", "downloaded task input", "task", task.Hash(), "resource",
+			task.InputResource.String(), "bytes", n, "duration", time.Since(began))
+	}
 	return
 }
 
@@ -241,7 +458,13 @@ func (l *taskLoader) Upload(ctx context.Context, task *Task, filePath string) (e
 	if filePath == "" {
 		return errors.New("empty filepath")
 	}
-	_, err = l.loader.Upload(ctx, task.OutputResource, filePath, map[string]*string{}, true)
+	began := time.Now()
+	n, err := l.loader.Upload(ctx, task.OutputResource, filePath, map[string]*string{}, true)
+	l.metrics.BytesUploaded.With("template", task.Template).Add(float64(n))
+	if err == nil {
+		level.Debug(l.logger).Log("msg", "uploaded task output", "task", task.Hash(), "resource",
+			task.OutputResource.String(), "bytes", n, "duration", time.Since(began))
+	}
 	return
 }
 
@@ -251,9 +474,13 @@ func (l *taskLoader) UploadMetadata(task *Task, metadata *ExecutionResult) error
 	}
 
 	for name, filePath := range metadata.MetadataPaths {
-		r := *task.MetadataResource
-		r.Url.Path = path.Join(r.Url.Path, task.Hash(), name)
-		if _, err := l.loader.Upload(context.Background(), &r, filePath, map[string]*string{}, true); err != nil {
+		// Copy the URL too, so the MetadataResource is not modified for the next upload.
+		u := *task.MetadataResource.Url
+		u.Path = path.Join(u.Path, task.Hash(), name)
+		r := &Resource{Url: &u, Phony: task.MetadataResource.Phony}
+		n, err := l.loader.Upload(context.Background(), r, filePath, map[string]*string{}, true)
+		l.metrics.BytesUploaded.With("template", task.Template).Add(float64(n))
+		if err != nil {
 			return err
 		}
 	}
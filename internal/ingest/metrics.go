@@ -0,0 +1,26 @@
+package ingest
+
+import (
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
+)
+
+// Metrics of the ingest service. The counters have a bucket label.
+type Metrics struct {
+	// Objects listed under ingested prefixes, those the filter matched and those inserted into the resource set.
+	ObjectsListed   metrics.Counter
+	ObjectsMatched  metrics.Counter
+	ObjectsInserted metrics.Counter
+	// Size of the resource set after the last ingest.
+	ResourceSetSize metrics.Gauge
+}
+
+// Metrics that are not recorded anywhere.
+func NopMetrics() *Metrics {
+	return &Metrics{
+		ObjectsListed:   discard.NewCounter(),
+		ObjectsMatched:  discard.NewCounter(),
+		ObjectsInserted: discard.NewCounter(),
+		ResourceSetSize: discard.NewGauge(),
+	}
+}
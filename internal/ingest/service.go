@@ -1,18 +1,27 @@
 package ingest
 
 import (
+	"context"
 	"fmt"
-	// "strings"
+	"strings"
 	"kroekerlabs.dev/chyme/services/pkg/aws"
 	"kroekerlabs.dev/chyme/services/internal/core"
 	"path/filepath"
 	"errors"
 	"net/url"
+	amzaws "github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"kroekerlabs.dev/chyme/services/pkg/tracing"
 )
 
 type IngestService interface {
-	Ingest(resource *core.Resource, filterString string, recursionDepth int) (int, error)
+	// Adds the resource, or the objects under it, to the resource set. The resources added carry the trace context
+	// of ctx to the tasker.
+	Ingest(ctx context.Context, resource *core.Resource, filterString string, recursionDepth int) (int, error)
 }
 
 /* fields that start with a lowercase letter are package internal
@@ -24,6 +33,10 @@ type Config struct {
 	// redis			    *redis.Client
 	ResourceSetKey      string
 	S3                  *s3.S3
+	// Logger of the service, which discards the log if nil.
+	Logger              log.Logger
+	// Metrics of the service, which are discarded if nil.
+	Metrics             *Metrics
 }
 
 type ingestService struct {
@@ -31,6 +44,12 @@ type ingestService struct {
 }
 
 func New(config Config) IngestService {
+	if config.Logger == nil {
+		config.Logger = log.NewNopLogger()
+	}
+	if config.Metrics == nil {
+		config.Metrics = NopMetrics()
+	}
 
 	svc := &ingestService{
 		config,
@@ -39,7 +58,15 @@ func New(config Config) IngestService {
 	return svc
 }
 
-func (i *ingestService) Ingest(resource *core.Resource, filterString string, recursionDepth int) (int, error) {
+func (i *ingestService) Ingest(ctx context.Context, resource *core.Resource, filterString string, recursionDepth int) (res int, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ingest", trace.WithAttributes(
+		attribute.String("bucket", resource.Url.Host),
+		attribute.String("prefix", resource.Url.Path),
+		attribute.Int("depth", recursionDepth),
+	))
+	defer func() { tracing.End(span, err) }()
+	traceContext := tracing.Inject(ctx)
+
 	//send 'ext/pdf' to use NewExtFilter or 'identity/...' for other method
 	filter, err := NewFilter(filterString)
 	if err != nil {
@@ -51,7 +78,7 @@ func (i *ingestService) Ingest(resource *core.Resource, filterString string, rec
 
 		if object == "" {
 			//if there is no file, we want to index an entire prefix
-			return i.ingestPrefix(resource, filter, recursionDepth)
+			return i.ingestPrefix(resource, filter, recursionDepth, traceContext)
 		} else {
 			return 0, fmt.Errorf("recursion depth specified but key %s is not a prefix.\n"+
 				"\tIf you want to ingest a prefix recursively, append a '/' to the key", resource.String())
@@ -63,20 +90,25 @@ func (i *ingestService) Ingest(resource *core.Resource, filterString string, rec
 	if err != nil {
 		return 0, err
 	}
-	res, err := i.ResourceRepository.Add(i.ResourceSetKey, filtered)
+	bucket := resource.Url.Host
+	i.Metrics.ObjectsListed.With("bucket", bucket).Add(1)
+	if filtered != nil {
+		i.Metrics.ObjectsMatched.With("bucket", bucket).Add(1)
+		filtered.TraceContext = traceContext
+	}
+	res, err = i.ResourceRepository.Add(i.ResourceSetKey, filtered)
 	if err != nil {
 		return 0, ErrEmpty
 	}
+	i.Metrics.ObjectsInserted.With("bucket", bucket).Add(float64(res))
 	return res, nil
 }
 
-func (i *ingestService) ingestPrefix(resource *core.Resource, filter FilterFunc, depth int) (int, error) {
-	fmt.Println("here in ingestPrefix")
-	fmt.Println(resource.Url.Scheme)
-	fmt.Println(resource.Url.Host)
-	fmt.Println(resource.Url.Path)
+func (i *ingestService) ingestPrefix(resource *core.Resource, filter FilterFunc, depth int, traceContext tracing.Carrier) (int, error) {
+	level.Debug(i.Logger).Log("msg", "ingesting prefix", "bucket", resource.Url.Host, "key", resource.Url.Path,
+		"depth", depth)
 
-	bucket := aws.NewS3Bucket(i.S3, resource.Url.Host) // strings.Trim(bucketUrl.Path, "/")
+	bucket := aws.NewS3Bucket(i.S3, resource.Url.Host, i.Logger) // strings.Trim(bucketUrl.Path, "/")
 
 	bi, err := i.ResourceRepository.BulkInsert(i.ResourceSetKey) 
 	if err != nil {
@@ -87,6 +119,7 @@ func (i *ingestService) ingestPrefix(resource *core.Resource, filter FilterFunc,
 		RootPrefix: resource.Url.Path,
 		Depth: depth,
 	}, func(obj *s3.Object) error {
+		i.Metrics.ObjectsListed.With("bucket", resource.Url.Host).Add(1)
 		newResource := filter(&core.Resource{
 			Url: &url.URL{
 				Scheme: resource.Url.Scheme, 
@@ -95,14 +128,25 @@ func (i *ingestService) ingestPrefix(resource *core.Resource, filter FilterFunc,
 			},
 		})
 		if newResource == nil {
-			fmt.Println("no new resource")
+			level.Debug(i.Logger).Log("msg", "object filtered out", "bucket", resource.Url.Host, "key", *obj.Key)
 			return nil
 		}
 		if err != nil {
-			fmt.Println(fmt.Errorf("error: %s", err))
 			return err
 		}
-		return bi.Insert(newResource)
+		i.Metrics.ObjectsMatched.With("bucket", resource.Url.Host).Add(1)
+		newResource.TraceContext = traceContext
+		newResource.Metadata = &core.ResourceMetadata{
+			Size:         amzaws.Int64Value(obj.Size),
+			Modified:     amzaws.TimeValue(obj.LastModified),
+			ETag:         strings.Trim(amzaws.StringValue(obj.ETag), `"`),
+			StorageClass: amzaws.StringValue(obj.StorageClass),
+		}
+		if err := bi.Insert(newResource); err != nil {
+			return err
+		}
+		i.Metrics.ObjectsInserted.With("bucket", resource.Url.Host).Add(1)
+		return nil
 	})
 
 	if err != nil {
@@ -115,6 +159,7 @@ func (i *ingestService) ingestPrefix(resource *core.Resource, filter FilterFunc,
 	if err != nil {
 		return 0, err
 	}
+	i.Metrics.ResourceSetSize.Set(float64(bucketObjectCount))
 	return bucketObjectCount, nil
 }
 
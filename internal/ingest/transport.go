@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"github.com/go-kit/kit/endpoint"
 	"kroekerlabs.dev/chyme/services/internal/core"
+	"kroekerlabs.dev/chyme/services/pkg/tracing"
 )
 
 // gRPC requests
@@ -24,14 +25,14 @@ type IngestResponse struct {
 
 /** function names beginning with a lowercase letter are not exported from the package **/
 func MakeIngestEndpoint(svc IngestService) endpoint.Endpoint {
-	return func (_ context.Context, request interface{}) (interface{}, error) {
+	return func (ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(IngestRequest)
 		sourceUrl, err := url.Parse(req.URL)
 
 		if err != nil {
 			return IngestResponse{0, err.Error()}, err
 		}
-		res, err := svc.Ingest(&core.Resource{Url: sourceUrl}, req.Filter, req.RecursionDepth)
+		res, err := svc.Ingest(ctx, &core.Resource{Url: sourceUrl}, req.Filter, req.RecursionDepth)
 		if err != nil {
 			return IngestResponse{res, err.Error()}, nil
 		}
@@ -39,6 +40,17 @@ func MakeIngestEndpoint(svc IngestService) endpoint.Endpoint {
 	}
 }
 
+// Continues the trace of the request's traceparent header, if it has one. It is an httptransport.RequestFunc.
+func ExtractTraceContext(ctx context.Context, r *http.Request) context.Context {
+	carrier := tracing.Carrier{}
+	for _, field := range tracing.Fields() {
+		if value := r.Header.Get(field); value != "" {
+			carrier.Set(field, value)
+		}
+	}
+	return tracing.Extract(ctx, carrier)
+}
+
 func DecodeIngestRequest(_ context.Context, r *http.Request) (interface{}, error) {
 	var request IngestRequest
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
@@ -0,0 +1,80 @@
+package tasker
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"kroekerlabs.dev/chyme/services/internal/core"
+)
+
+// ChunkSpec configures how a chunked Template fans a Task out.
+//
+// In bytes mode each of the Count chunk Tasks downloads an equal byte range of the input object. In time mode each
+// chunk Task downloads the whole input and is told (through CH_CHUNK_START and CH_CHUNK_DURATION) to process one
+// Segment of it; the last chunk runs to the end of the input.
+type ChunkSpec struct {
+	Mode    core.ChunkMode
+	Count   int
+	Segment time.Duration
+}
+
+func (c *ChunkSpec) Validate() error {
+	if c.Count < 2 {
+		return fmt.Errorf("chunk count must be at least 2, got %d", c.Count)
+	}
+	switch c.Mode {
+	case core.ChunkBytes:
+	case core.ChunkTime:
+		if c.Segment <= 0 {
+			return errors.New("time chunks need a positive segment length")
+		}
+	default:
+		return fmt.Errorf("unknown chunk mode %q", c.Mode)
+	}
+	return nil
+}
+
+// Creates the chunk Tasks for base, which carry its Template name. Each chunk writes its output to chunk-<index>/ under base's OutputResource, which
+// is the InputResource of join. The chunks belong to the chain identified by the join Task's hash unless they are
+// enqueued as part of an existing chain.
+func (c *ChunkSpec) fanOut(base *core.Task, join *core.Task) ([]*core.Task, error) {
+	if !strings.HasSuffix(base.OutputResource.Url.Path, "/") {
+		return nil, fmt.Errorf("chunked output %s must be a prefix ending in /", base.OutputResource.String())
+	}
+
+	tasks := make([]*core.Task, c.Count)
+	for i := 0; i < c.Count; i++ {
+		chunk := &core.Chunk{
+			Group: join.Hash(),
+			Index: i,
+			Count: c.Count,
+			Mode:  c.Mode,
+		}
+		if c.Mode == core.ChunkTime {
+			chunk.Start = c.Segment * time.Duration(i)
+			if i < c.Count-1 {
+				chunk.Duration = c.Segment
+			}
+		}
+
+		outUrl := *base.OutputResource.Url
+		outUrl.Path += fmt.Sprintf("chunk-%04d/", i)
+
+		tasks[i] = &core.Task{
+			InputResource:     base.InputResource,
+			OutputResource:    &core.Resource{Url: &outUrl},
+			MetadataResource:  base.MetadataResource,
+			ExecutionStrategy: base.ExecutionStrategy,
+			Hooks:             base.Hooks,
+			Timeout:           base.Timeout,
+			Version:           base.Version,
+			Template:          base.Template,
+			ParentID:          join.Hash(),
+			Chunk:             chunk,
+			Join:              join,
+		}
+	}
+	return tasks, nil
+}
@@ -0,0 +1,27 @@
+package tasker
+
+import (
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
+)
+
+// Metrics of the tasker service.
+type Metrics struct {
+	// Tasks created and enqueued, with a template label.
+	TasksCreated metrics.Counter
+	// Resources moved to the reject set because their Tasks could not be created.
+	ResourcesRejected metrics.Counter
+	// Resources waiting in the resource set and messages waiting in the task queue, as of the last poll.
+	ResourceSetSize metrics.Gauge
+	QueueDepth      metrics.Gauge
+}
+
+// Metrics that are not recorded anywhere.
+func NopMetrics() *Metrics {
+	return &Metrics{
+		TasksCreated:      discard.NewCounter(),
+		ResourcesRejected: discard.NewCounter(),
+		ResourceSetSize:   discard.NewGauge(),
+		QueueDepth:        discard.NewGauge(),
+	}
+}
@@ -0,0 +1,144 @@
+package tasker
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+
+	"kroekerlabs.dev/chyme/services/internal/core"
+)
+
+// OutputTemplate renders the URL of a Task's OutputResource from its InputResource. Patterns use text/template
+// syntax, e.g.
+//
+//	s3://{{env "BUCKET"}}/{{.Host}}/{{.Dir}}/{{.Stem}}/
+//
+// Fields available to a pattern are those of OutputData. Functions available are env, date, lower, upper, trimPrefix,
+// trimSuffix and replace. Empty path segments are collapsed, so `{{.Dir}}` may be empty.
+type OutputTemplate struct {
+	pattern string
+	tmpl    *template.Template
+}
+
+// OutputData is the data an OutputTemplate pattern is executed against.
+type OutputData struct {
+	Scheme string // s3
+	Host   string // bucket
+	Path   string // /dir/sub/video.MOV
+	Key    string // dir/sub/video.MOV
+	Dir    string // dir/sub
+	Base   string // video.MOV
+	Stem   string // video
+	Ext    string // .MOV
+	Hash   string // SHA1 hash of the input resource URL
+	Date   time.Time
+	// Metadata of the input object listed by the ingest: .Meta.Size, .Meta.Modified, .Meta.ETag and
+	// .Meta.StorageClass. Nil for resources ingested by key, so a pattern using it fails for them.
+	Meta *core.ResourceMetadata
+}
+
+// Resource used to validate OutputTemplates before any real Resources are seen.
+var sampleResource = &core.Resource{
+	Url:      &url.URL{Scheme: "s3", Host: "example-bucket", Path: "/dir/example.mov"},
+	Metadata: &core.ResourceMetadata{Size: 1 << 20, Modified: time.Date(2020, 8, 16, 0, 0, 0, 0, time.UTC)},
+}
+
+func NewOutputTemplate(pattern string) (*OutputTemplate, error) {
+	if pattern == "" {
+		return nil, errors.New("empty output pattern")
+	}
+
+	tmpl, err := template.New("output").Option("missingkey=error").Funcs(template.FuncMap{
+		"env":        os.Getenv,
+		"date":       func(layout string) string { return time.Now().UTC().Format(layout) },
+		"lower":      strings.ToLower,
+		"upper":      strings.ToUpper,
+		"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+		"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+		"replace":    func(old, new, s string) string { return strings.Replace(s, old, new, -1) },
+	}).Parse(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output pattern %q: %s", pattern, err.Error())
+	}
+	return &OutputTemplate{pattern, tmpl}, nil
+}
+
+// Like NewOutputTemplate but panics if the pattern cannot be parsed. Intended for package level Template variables.
+func MustOutputTemplate(pattern string) *OutputTemplate {
+	t, err := NewOutputTemplate(pattern)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func (t *OutputTemplate) String() string {
+	return t.pattern
+}
+
+// Renders the output URL for resource. The rendered URL must have a scheme and a host.
+func (t *OutputTemplate) Execute(resource *core.Resource) (*url.URL, error) {
+	var buf bytes.Buffer
+	if err := t.tmpl.Execute(&buf, NewOutputData(resource)); err != nil {
+		return nil, fmt.Errorf("failed to render output pattern %q: %s", t.pattern, err.Error())
+	}
+
+	u, err := url.Parse(buf.String())
+	if err != nil {
+		return nil, fmt.Errorf("output pattern %q rendered an invalid url: %s", t.pattern, err.Error())
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("output pattern %q rendered %q, which has no scheme or host", t.pattern, u.String())
+	}
+	u.Path = cleanPath(u.Path)
+	return u, nil
+}
+
+// Checks that the pattern renders a valid URL in the current environment.
+func (t *OutputTemplate) Validate() error {
+	_, err := t.Execute(sampleResource)
+	return err
+}
+
+func NewOutputData(resource *core.Resource) *OutputData {
+	key := strings.TrimPrefix(resource.Url.Path, "/")
+	dir := path.Dir(key)
+	if dir == "." {
+		dir = ""
+	}
+	base := path.Base(key)
+	ext := path.Ext(base)
+
+	return &OutputData{
+		Scheme: resource.Url.Scheme,
+		Host:   resource.Url.Host,
+		Path:   resource.Url.Path,
+		Key:    key,
+		Dir:    dir,
+		Base:   base,
+		Stem:   strings.TrimSuffix(base, ext),
+		Ext:    ext,
+		Hash:   resource.Hash(),
+		Date:   time.Now().UTC(),
+		Meta:   resource.Metadata,
+	}
+}
+
+// Collapses empty segments in a rendered path while preserving a trailing slash, which marks a prefix.
+func cleanPath(p string) string {
+	if p == "" {
+		return p
+	}
+	isPfx := strings.HasSuffix(p, "/")
+	p = path.Clean("/" + p)
+	if isPfx && p != "/" {
+		p += "/"
+	}
+	return p
+}
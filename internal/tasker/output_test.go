@@ -0,0 +1,139 @@
+package tasker
+
+import (
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"kroekerlabs.dev/chyme/services/internal/core"
+)
+
+func TestOutputTemplateExecute(t *testing.T) {
+	os.Setenv("CH_TEST_OUTPUT_BUCKET", "processed")
+	defer os.Unsetenv("CH_TEST_OUTPUT_BUCKET")
+
+	video := &core.Resource{Url: &url.URL{Scheme: "s3", Host: "raw", Path: "/dir/sub/Video.MOV"}}
+	topLevel := &core.Resource{Url: &url.URL{Scheme: "s3", Host: "raw", Path: "/video.mp4"}}
+	listed := &core.Resource{
+		Url: &url.URL{Scheme: "s3", Host: "raw", Path: "/video.mp4"},
+		Metadata: &core.ResourceMetadata{
+			Size:         1024,
+			Modified:     time.Date(2020, 8, 16, 0, 0, 0, 0, time.UTC),
+			StorageClass: "GLACIER",
+		},
+	}
+
+	tests := []struct {
+		name     string
+		pattern  string
+		resource *core.Resource
+		want     string
+		wantErr  bool
+	}{
+		{
+			name:     "mirror",
+			pattern:  "s3://processed/H264/{{.Host}}/{{.Key}}/",
+			resource: video,
+			want:     "s3://processed/H264/raw/dir/sub/Video.MOV/",
+		},
+		{
+			name:     "path fields",
+			pattern:  "s3://processed/{{.Dir}}/{{.Stem}}{{lower .Ext}}",
+			resource: video,
+			want:     "s3://processed/dir/sub/Video.mov",
+		},
+		{
+			name:     "empty dir collapsed",
+			pattern:  "s3://processed/{{.Dir}}/{{.Stem}}/",
+			resource: topLevel,
+			want:     "s3://processed/video/",
+		},
+		{
+			name:     "env bucket",
+			pattern:  `s3://{{env "CH_TEST_OUTPUT_BUCKET"}}/{{.Base}}`,
+			resource: topLevel,
+			want:     "s3://processed/video.mp4",
+		},
+		{
+			name:     "functions",
+			pattern:  `s3://processed/{{upper (trimSuffix .Ext .Base)}}/{{replace "/" "-" (trimPrefix "dir/" .Key)}}`,
+			resource: video,
+			want:     "s3://processed/VIDEO/sub-Video.MOV",
+		},
+		{
+			name:     "hash",
+			pattern:  "s3://processed/{{.Hash}}/",
+			resource: video,
+			want:     "s3://processed/" + video.Hash() + "/",
+		},
+		{
+			name:     "metadata",
+			pattern:  `s3://processed/{{.Meta.StorageClass}}/{{.Meta.Modified.Format "2006"}}/{{.Meta.Size}}`,
+			resource: listed,
+			want:     "s3://processed/GLACIER/2020/1024",
+		},
+		{
+			name:     "metadata of resource ingested by key",
+			pattern:  "s3://processed/{{.Meta.Size}}",
+			resource: topLevel,
+			wantErr:  true,
+		},
+		{
+			name:     "unset env leaves no host",
+			pattern:  `s3://{{env "CH_TEST_OUTPUT_UNSET"}}/{{.Key}}`,
+			resource: topLevel,
+			wantErr:  true,
+		},
+		{name: "no scheme", pattern: "processed/{{.Key}}", resource: topLevel, wantErr: true},
+		{name: "unknown field", pattern: "s3://processed/{{.Bucket}}", resource: topLevel, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := NewOutputTemplate(tt.pattern)
+			if err != nil {
+				t.Fatalf("NewOutputTemplate() = %v", err)
+			}
+			got, err := output.Execute(tt.resource)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %t", err, tt.wantErr)
+			}
+			if err == nil && got.String() != tt.want {
+				t.Errorf("Execute() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewOutputTemplateInvalid(t *testing.T) {
+	for _, pattern := range []string{"", "s3://processed/{{.Key}", "s3://processed/{{unknown .Key}}"} {
+		if _, err := NewOutputTemplate(pattern); err == nil {
+			t.Errorf("NewOutputTemplate(%q) = nil error, want error", pattern)
+		}
+	}
+}
+
+func TestCleanPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"", ""},
+		{"/", "/"},
+		{"//", "/"},
+		{"/a/b", "/a/b"},
+		{"/a/b/", "/a/b/"},
+		{"a/b", "/a/b"},
+		{"/a//b", "/a/b"},
+		{"//a///b//", "/a/b/"},
+		{"/a/./b/", "/a/b/"},
+		{"/a/../b", "/b"},
+	}
+
+	for _, tt := range tests {
+		if got := cleanPath(tt.path); got != tt.want {
+			t.Errorf("cleanPath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
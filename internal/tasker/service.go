@@ -1,8 +1,16 @@
 package tasker
 
 import (
+	"context"
 	"fmt"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"kroekerlabs.dev/chyme/services/internal/core"
+	"kroekerlabs.dev/chyme/services/pkg/tracing"
 )
 
 type Service interface {
@@ -23,9 +31,19 @@ type Config struct {
 	Templater          Templater
 
 	BatchSize          int
+	// Logger of the service, which discards the log if nil.
+	Logger             log.Logger
+	// Metrics of the service, which are discarded if nil.
+	Metrics            *Metrics
 }
 
 func New(config *Config) Service {
+	if config.Logger == nil {
+		config.Logger = log.NewNopLogger()
+	}
+	if config.Metrics == nil {
+		config.Metrics = NopMetrics()
+	}
 	return &service{
 		Config: config,
 	}
@@ -35,17 +53,17 @@ func New(config *Config) Service {
 // and the task queue as the task destination
 func (s *service) CreateTasks(count int) (int, error) {
 	sources, err := s.ResourceRepository.Pop(s.ResourceSetKey, count)
-	fmt.Println("sources for tasks")
-	fmt.Println(sources)
 	if err != nil {
 		return 0, err 
 	}
+	level.Debug(s.Logger).Log("msg", "popped resources", "set", s.ResourceSetKey, "count", len(sources))
 
 	// In the loop below we shift resources out of the source slice once they are successfully processed. This defer
 	// will add any resources that have not been shifted off (i.e. failed processing) back to the set for another
-	// attempt at processing.
+	// attempt at processing, marking them as retried so that the attempt skips the Tasks already enqueued.
 	defer func() {
 		if len(sources) > 0 {
+			_, _ = s.ResourceRepository.Add(s.retrySetKey(), sources...)
 			_, _ = s.ResourceRepository.Add(s.ResourceSetKey, sources...)
 		}
 	}()
@@ -53,11 +71,22 @@ func (s *service) CreateTasks(count int) (int, error) {
 	created := 0
 	for len(sources) > 0 {
 		source := sources[0]
-		tasks := s.Templater.Create(source)
-		c, err := s.enqueueTasks(tasks, false)
+		c, tasks, err := s.createFrom(source)
+		if _, ok := err.(*templateError); ok {
+			// The templates would fail the same way on every attempt, so the resource is set aside instead.
+			if err := s.reject(source, err); err != nil {
+				return created, err
+			}
+			sources = sources[1:]
+			continue
+		}
 		if err != nil {
 			return created, err
 		}
+		for _, task := range tasks {
+			level.Debug(s.Logger).Log("msg", "task created", "task", task.Hash(), "template", task.Template,
+				"resource", source.String())
+		}
 		created += c
 		sources = sources[1:]
 	}
@@ -65,6 +94,55 @@ func (s *service) CreateTasks(count int) (int, error) {
 	return created, nil
 }
 
+// Creates and enqueues the tasks of a resource, continuing the trace of its ingest.
+func (s *service) createFrom(source *core.Resource) (created int, tasks []*core.Task, err error) {
+	ctx, span := tracing.Tracer().Start(tracing.Extract(context.Background(), source.TraceContext), "tasker.create",
+		trace.WithAttributes(attribute.String("resource", source.String())))
+	defer func() { tracing.End(span, err) }()
+
+	tasks, err = s.Templater.Create(source)
+	if err != nil {
+		return 0, nil, &templateError{err}
+	}
+	retried, err := s.ResourceRepository.Remove(s.retrySetKey(), source)
+	if err != nil {
+		return 0, nil, err
+	}
+	created, err = s.enqueueTasks(ctx, tasks, retried > 0)
+	return created, tasks, err
+}
+
+// Error of the Templater creating the Tasks of a resource, as opposed to failing to enqueue them.
+type templateError struct {
+	err error
+}
+
+func (e *templateError) Error() string {
+	return e.err.Error()
+}
+
+// Moves a resource the Templater failed on to the reject set, from which it can be moved back to the resource set
+// once the templates are fixed.
+func (s *service) reject(source *core.Resource, err error) error {
+	if _, addErr := s.ResourceRepository.Add(s.rejectSetKey(), source); addErr != nil {
+		return fmt.Errorf("failed to reject %s: %s", source.String(), addErr.Error())
+	}
+	level.Error(s.Logger).Log("msg", "rejected resource", "resource", source.String(), "set", s.rejectSetKey(),
+		"err", err)
+	s.Metrics.ResourcesRejected.Add(1)
+	return nil
+}
+
+// Key of the set of resources the Templater failed on.
+func (s *service) rejectSetKey() string {
+	return s.ResourceSetKey + ":rejected"
+}
+
+// Key of the set of resources put back after failing to enqueue their Tasks.
+func (s *service) retrySetKey() string {
+	return s.ResourceSetKey + ":retry"
+}
+
 func (s *service) ShouldCreate() (int, error) {
 	// messageCount, err := s.TaskQueue.MessageCount()
 	// if err != nil {
@@ -83,13 +161,17 @@ func (s *service) ShouldCreate() (int, error) {
 }
 
 func (s *service) Poll() error {
-	fmt.Println("poll")
+	began := time.Now()
 	count, err := s.ShouldCreate()
 	if err != nil {
 		return err
 	}
 
-	_, err = s.CreateTasks(count)
+	created, err := s.CreateTasks(count)
+	if created > 0 {
+		level.Info(s.Logger).Log("msg", "tasks created", "count", created, "duration", time.Since(began))
+	}
+	s.recordBacklog()
 	if err != nil {
 		return err
 	}
@@ -97,33 +179,37 @@ func (s *service) Poll() error {
 	return nil
 }
 
-func (s *service) enqueueTasks(tasks []*core.Task, shouldDeduplicate bool) (int, error) {
+// Records the sizes of the resource set and the task queue. Failing to read them does not fail the poll.
+func (s *service) recordBacklog() {
+	if size, err := s.ResourceRepository.Count(s.ResourceSetKey); err != nil {
+		level.Warn(s.Logger).Log("msg", "failed to count resource set", "set", s.ResourceSetKey, "err", err)
+	} else {
+		s.Metrics.ResourceSetSize.Set(float64(size))
+	}
+	if depth, err := s.TaskQueue.MessageCount(); err != nil {
+		level.Warn(s.Logger).Log("msg", "failed to count queued tasks", "err", err)
+	} else {
+		s.Metrics.QueueDepth.Set(float64(depth))
+	}
+}
+
+func (s *service) enqueueTasks(ctx context.Context, tasks []*core.Task, shouldDeduplicate bool) (int, error) {
 	created := 0
 
 	for _, task := range tasks {
-		// if shouldDeduplicate {
-		// 	exists, err := s.TaskRepository.Has(task)
-		// 	if err != nil {
-		// 		return created, err
-		// 	}
-		// 	if exists {
-		// 		continue
-		// 	}
-		// }
-
-		queue := s.TaskQueue
-		// if task.QueueAffinity != "" {
-		// 	q, ok := s.AlternateQueues.Get(task.QueueAffinity)
-		// 	if !ok {
-		// 		return created, errors.New("unknown queue " + task.QueueAffinity)
-		// 	}
-		// 	queue = q 
-		// }
-		if err := queue.Enqueue(task); err != nil {
-			return created, err 
+		if shouldDeduplicate {
+			exists, err := s.TaskRepository.Has(task)
+			if err != nil {
+				return created, err
+			}
+			if exists {
+				level.Debug(s.Logger).Log("msg", "task already enqueued", "task", task.Hash(), "template", task.Template)
+				continue
+			}
 		}
-		if err := s.TaskRepository.Add(task); err != nil {
-			return created, err 
+
+		if err := s.enqueueTask(ctx, task); err != nil {
+			return created, err
 		}
 		created++
 	}
@@ -131,6 +217,39 @@ func (s *service) enqueueTasks(tasks []*core.Task, shouldDeduplicate bool) (int,
 	return created, nil
 }
 
+// Enqueues a Task in a span whose context the Task carries to the worker.
+func (s *service) enqueueTask(ctx context.Context, task *core.Task) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "tasker.enqueue", trace.WithAttributes(
+		attribute.String("task.hash", task.Hash()),
+		attribute.String("task.template", task.Template),
+	))
+	defer func() { tracing.End(span, err) }()
+	task.TraceContext = tracing.Inject(ctx)
+
+	queue := s.TaskQueue
+	// if task.QueueAffinity != "" {
+	// 	q, ok := s.AlternateQueues.Get(task.QueueAffinity)
+	// 	if !ok {
+	// 		return errors.New("unknown queue " + task.QueueAffinity)
+	// 	}
+	// 	queue = q 
+	// }
+	if err := queue.Enqueue(task); err != nil {
+		return err 
+	}
+	if err := s.TaskRepository.Add(task); err != nil {
+		return err 
+	}
+	s.Metrics.TasksCreated.With("template", task.Template).Add(1)
+	// The first Tasks of a chain or fan-out are recorded so the whole chain can be found by its ID.
+	if len(task.Next) > 0 || task.Join != nil {
+		if err := s.TaskRepository.AddToChain(task); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // func (s *service) MessageCountSMA() float32 {
 // 	s.mcSMALock.RLock()
 // 	defer s.mcSMALock.RUnlock()
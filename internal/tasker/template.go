@@ -1,39 +1,190 @@
 package tasker
 
 import (
+	"fmt"
+
 	"kroekerlabs.dev/chyme/services/internal/core"
 )
 
 type Template struct {
 	Name string
+	// Renders the OutputResource of each Task created by the Template.
+	Output *OutputTemplate
 	Create func(resource *core.Resource) *core.Task 
+	// Names of the Templates applied to the OutputResource of this Template's Tasks once they complete.
+	Next []string
+	// FollowUp Templates are only applied through another Template's Next, never to ingested Resources.
+	FollowUp bool
+	// Splits each Task into chunk Tasks. Join names the Template applied to the combined output of the chunks once
+	// all of them complete.
+	Chunks *ChunkSpec
+	Join   string
+	// ExecutionStrategy of the Template's Tasks, validated with the Template.
+	Strategy *core.ExecutionStrategy
+	// Version of the Template's Tasks, the Templater's unless set.
+	Version string
+}
+
+// Checks that the Template is well formed in the current environment.
+func (t *Template) Validate() error {
+	if t.Output == nil {
+		return fmt.Errorf("template %s: no output pattern", t.Name)
+	}
+	if err := t.Output.Validate(); err != nil {
+		return fmt.Errorf("template %s: %s", t.Name, err.Error())
+	}
+	if t.Strategy == nil {
+		return fmt.Errorf("template %s: no execution strategy", t.Name)
+	}
+	if err := t.Strategy.Validate(); err != nil {
+		return fmt.Errorf("template %s: %s", t.Name, err.Error())
+	}
+	if t.Chunks != nil {
+		if err := t.Chunks.Validate(); err != nil {
+			return fmt.Errorf("template %s: %s", t.Name, err.Error())
+		}
+		if t.Join == "" {
+			return fmt.Errorf("template %s: chunked template has no join template", t.Name)
+		}
+		if len(t.Next) > 0 {
+			return fmt.Errorf("template %s: chunked template cannot have next templates, add them to the join template", t.Name)
+		}
+	} else if t.Join != "" {
+		return fmt.Errorf("template %s: join template set without chunks", t.Name)
+	}
+	return nil
 }
 
 type Templater interface {
-	Create(resource *core.Resource) []*core.Task 
+	Create(resource *core.Resource) ([]*core.Task, error)
 	Reload() error 
 }
 
 type inMemTemplater struct {
 	templates []*Template 
+	byName    map[string]*Template
 	version   string
 }
 
 func NewInMemTemplater(templates []*Template, version string) Templater {
-	return &inMemTemplater{templates, version}
+	return &inMemTemplater{templates, templatesByName(templates), version}
 }
 
-func (t *inMemTemplater) Create(resource *core.Resource) []*core.Task {
+// Validates each of the templates, returning the first error encountered. Follow-up Templates named in Next must be
+// among templates and must not lead back to the Template that names them.
+func ValidateTemplates(templates []*Template) error {
+	byName := templatesByName(templates)
+	for _, template := range templates {
+		if err := template.Validate(); err != nil {
+			return err
+		}
+		if err := checkChain(template, byName, map[string]bool{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *inMemTemplater) Create(resource *core.Resource) ([]*core.Task, error) {
 	tasks := make([]*core.Task, 0)
 	for _, template := range t.templates {
-		if task := template.Create(resource); task != nil {
-			task.Version = t.version
-			tasks = append(tasks, task)
+		if template.FollowUp {
+			continue
+		}
+		created, err := t.create(template, resource)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, created...)
+	}
+	return tasks, nil
+}
+
+// Creates a Task from template, along with the chain of follow-up Tasks declared by the template's Next. Chunked
+// templates create one Task per chunk instead, each carrying the join Task.
+func (t *inMemTemplater) create(template *Template, resource *core.Resource) ([]*core.Task, error) {
+	task := template.Create(resource)
+	if task == nil {
+		return nil, nil
+	}
+
+	outUrl, err := template.Output.Execute(resource)
+	if err != nil {
+		return nil, fmt.Errorf("template %s: %s", template.Name, err.Error())
+	}
+	task.OutputResource = &core.Resource{Url: outUrl}
+	task.Version = t.version
+	if template.Version != "" {
+		task.Version = template.Version
+	}
+	task.Template = template.Name
+
+	if template.Chunks != nil {
+		joinTemplate, ok := t.byName[template.Join]
+		if !ok {
+			return nil, fmt.Errorf("template %s: unknown join template %s", template.Name, template.Join)
+		}
+		// The join Task's input is the prefix the chunk Tasks write their output under.
+		joins, err := t.create(joinTemplate, task.OutputResource)
+		if err != nil {
+			return nil, err
+		}
+		if len(joins) != 1 {
+			return nil, fmt.Errorf("template %s: join template %s must create exactly one task", template.Name, template.Join)
 		}
+		chunks, err := template.Chunks.fanOut(task, joins[0])
+		if err != nil {
+			return nil, fmt.Errorf("template %s: %s", template.Name, err.Error())
+		}
+		return chunks, nil
+	}
+
+	for _, name := range template.Next {
+		nextTemplate, ok := t.byName[name]
+		if !ok {
+			return nil, fmt.Errorf("template %s: unknown next template %s", template.Name, name)
+		}
+		next, err := t.create(nextTemplate, task.OutputResource)
+		if err != nil {
+			return nil, err
+		}
+		// A follow-up Template may decline the output of the previous step.
+		task.Next = append(task.Next, next...)
 	}
-	return tasks
+	return []*core.Task{task}, nil
 }
 
 func (t *inMemTemplater) Reload() error {
 	return nil
-}
\ No newline at end of file
+}
+
+func templatesByName(templates []*Template) map[string]*Template {
+	byName := make(map[string]*Template, len(templates))
+	for _, template := range templates {
+		byName[template.Name] = template
+	}
+	return byName
+}
+
+func checkChain(template *Template, byName map[string]*Template, visiting map[string]bool) error {
+	if visiting[template.Name] {
+		return fmt.Errorf("template %s: next templates form a cycle", template.Name)
+	}
+	visiting[template.Name] = true
+	defer delete(visiting, template.Name)
+
+	names := template.Next
+	if template.Join != "" {
+		names = append([]string{template.Join}, names...)
+	}
+	for _, name := range names {
+		next, ok := byName[name]
+		if !ok {
+			return fmt.Errorf("template %s: unknown next template %s", template.Name, name)
+		}
+		if err := checkChain(next, byName, visiting); err != nil {
+			return err
+		}
+	}
+	return nil
+}
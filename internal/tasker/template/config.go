@@ -0,0 +1,174 @@
+package template
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"kroekerlabs.dev/chyme/services/internal/core"
+	"kroekerlabs.dev/chyme/services/internal/tasker"
+)
+
+// Config holds the settings of a compiled-in Template. It is read once at startup, from the tasker's configuration
+// file and the CH_TEMPLATE_<NAME>_* environment variables, which take precedence:
+//
+//	CH_TEMPLATE_<NAME>_TIMEOUT         Go duration with a unit, e.g. 90m
+//	CH_TEMPLATE_<NAME>_IMAGE           image reference, including the tag
+//	CH_TEMPLATE_<NAME>_MIRROR_BUCKET   bucket the output is mirrored into (required)
+//	CH_TEMPLATE_<NAME>_MIRROR_PREFIX   prefix the output is mirrored under
+//	CH_TEMPLATE_<NAME>_LOGGING_BUCKET  bucket task metadata is uploaded to, defaults to CH_TEMPLATE_LOGGING_BUCKET
+//	CH_TEMPLATE_<NAME>_LOGGING_PREFIX  prefix task metadata is uploaded under, defaults to CH_TEMPLATE_LOGGING_PREFIX
+//
+// CH_TEMPLATE_MIE4NITF_LOGGING_BUCKET and CH_TEMPLATE_MIE4NITF_LOGGING_PREFIX, which every template used to upload
+// task metadata with, are still read when CH_TEMPLATE_LOGGING_BUCKET and CH_TEMPLATE_LOGGING_PREFIX are unset.
+type Config struct {
+	Name          string        `yaml:"-"`
+	Timeout       time.Duration `yaml:"timeout"`
+	Image         string        `yaml:"image"`
+	MirrorBucket  string        `yaml:"mirrorBucket"`
+	MirrorPrefix  string        `yaml:"mirrorPrefix"`
+	LoggingBucket string        `yaml:"loggingBucket"`
+	LoggingPrefix string        `yaml:"loggingPrefix"`
+
+	// Output pattern rendered from the mirror bucket and prefix by LoadConfig.
+	output *tasker.OutputTemplate
+}
+
+// Variables the logging bucket and prefix of every template were read from before CH_TEMPLATE_LOGGING_*.
+const (
+	legacyLoggingBucketKey = "CH_TEMPLATE_MIE4NITF_LOGGING_BUCKET"
+	legacyLoggingPrefixKey = "CH_TEMPLATE_MIE4NITF_LOGGING_PREFIX"
+)
+
+// Returns the settings of c, with those that are unset taken from defaults.
+func (c Config) Or(defaults Config) Config {
+	if c.Timeout == 0 {
+		c.Timeout = defaults.Timeout
+	}
+	if c.Image == "" {
+		c.Image = defaults.Image
+	}
+	if c.MirrorBucket == "" {
+		c.MirrorBucket = defaults.MirrorBucket
+	}
+	if c.MirrorPrefix == "" {
+		c.MirrorPrefix = defaults.MirrorPrefix
+	}
+	if c.LoggingBucket == "" {
+		c.LoggingBucket = defaults.LoggingBucket
+	}
+	if c.LoggingPrefix == "" {
+		c.LoggingPrefix = defaults.LoggingPrefix
+	}
+	return c
+}
+
+// Reads the Config for the Template named name from the environment, starting from defaults. All invalid settings are
+// reported together.
+func LoadConfig(name string, defaults Config) (*Config, error) {
+	c := defaults
+	c.Name = name
+	errs := &multierror.Error{}
+
+	if timeout := c.env("TIMEOUT"); timeout != "" {
+		if d, err := parseTimeout(timeout); err != nil {
+			errs = multierror.Append(errs, fmt.Errorf("%s: %s", c.key("TIMEOUT"), err.Error()))
+		} else {
+			c.Timeout = d
+		}
+	}
+	if image := c.env("IMAGE"); image != "" {
+		c.Image = image
+	}
+	c.MirrorBucket = c.envOr("MIRROR_BUCKET", c.MirrorBucket)
+	c.MirrorPrefix = c.envOr("MIRROR_PREFIX", c.MirrorPrefix)
+	c.LoggingBucket = c.envOr("LOGGING_BUCKET", envOr("CH_TEMPLATE_LOGGING_BUCKET",
+		envOr(legacyLoggingBucketKey, c.LoggingBucket)))
+	c.LoggingPrefix = c.envOr("LOGGING_PREFIX", envOr("CH_TEMPLATE_LOGGING_PREFIX",
+		envOr(legacyLoggingPrefixKey, c.LoggingPrefix)))
+
+	if c.Timeout <= 0 {
+		errs = multierror.Append(errs, fmt.Errorf("%s: timeout must be positive", c.key("TIMEOUT")))
+	}
+	if c.Image == "" {
+		errs = multierror.Append(errs, fmt.Errorf("%s: no image specified", c.key("IMAGE")))
+	}
+	if c.MirrorBucket == "" {
+		errs = multierror.Append(errs, fmt.Errorf("%s: no mirror bucket specified", c.key("MIRROR_BUCKET")))
+	} else if output, err := c.newMirrorOutput(); err != nil {
+		errs = multierror.Append(errs, fmt.Errorf("%s, %s: %s", c.key("MIRROR_BUCKET"), c.key("MIRROR_PREFIX"),
+			err.Error()))
+	} else {
+		c.output = output
+	}
+
+	if err := errs.ErrorOrNil(); err != nil {
+		return nil, fmt.Errorf("invalid %s template configuration: %s", name, err.Error())
+	}
+	return &c, nil
+}
+
+// Output pattern that mirrors the input bucket and key under the mirror bucket and prefix, as validated by LoadConfig.
+func (c *Config) mirrorOutput() *tasker.OutputTemplate {
+	return c.output
+}
+
+// Builds the mirror output pattern. The bucket and prefix come from the environment, so they are quoted as string
+// literals rather than parsed as template code, and the pattern is checked to render a valid URL rather than trusted.
+func (c *Config) newMirrorOutput() (*tasker.OutputTemplate, error) {
+	output, err := tasker.NewOutputTemplate(fmt.Sprintf("s3://{{%q}}/{{%q}}/{{.Host}}/{{.Key}}/", c.MirrorBucket,
+		c.MirrorPrefix))
+	if err != nil {
+		return nil, err
+	}
+	if err := output.Validate(); err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+// Resource task metadata is uploaded to, or nil if no logging bucket is configured.
+func (c *Config) metadataResource() *core.Resource {
+	if c.LoggingBucket == "" {
+		return nil
+	}
+	return &core.Resource{Url: &url.URL{
+		Scheme: "s3",
+		Host:   c.LoggingBucket,
+		Path:   c.LoggingPrefix,
+	}}
+}
+
+func (c *Config) key(setting string) string {
+	return "CH_TEMPLATE_" + c.Name + "_" + setting
+}
+
+func (c *Config) env(setting string) string {
+	return os.Getenv(c.key(setting))
+}
+
+func (c *Config) envOr(setting string, fallback string) string {
+	return envOr(c.key(setting), fallback)
+}
+
+func envOr(key string, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// Parses a Go duration. A bare number is rejected rather than read in an assumed unit.
+func parseTimeout(s string) (time.Duration, error) {
+	if _, err := strconv.Atoi(s); err == nil {
+		return 0, fmt.Errorf("timeout %q has no unit, expected a duration such as 90m", s)
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timeout %q, expected a duration such as 90m", s)
+	}
+	return d, nil
+}
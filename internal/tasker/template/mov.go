@@ -1,7 +1,6 @@
 package template
 
 import (
-	"os"
 	"path"
 	"strings"
 	"time"
@@ -10,37 +9,32 @@ import (
 	"kroekerlabs.dev/chyme/services/internal/tasker"
 )
 
-func init() {
-	if timeout := os.Getenv("CH_TEMPLATE_MOV_TIMEOUT"); timeout != "" {
-
-	}
+var MovDefaults = Config{
+	Timeout: time.Duration(48) * time.Hour,
+	Image:   "jnkroeker/mov_converter:0.1.4",
 }
 
-var movTimeout = time.Duration(48) * time.Hour
-
-var Mov = &tasker.Template{
-	Name: "Mov",
-	Create: func(resource *core.Resource) *core.Task {
-		if strings.ToLower(path.Ext(resource.Url.Path)) != ".mov" {
-			return nil
-		}
-
-		outUrl := *resource.Url
-		outUrl.Path = path.Join(os.Getenv("CH_TEMPLATE_MOV_MIRROR_PREFIX"), outUrl.Host, outUrl.Path) + "/"
-		outUrl.Host = os.Getenv("CH_TEMPLATE_MOV_MIRROR_BUCKET")
-
-		return &core.Task{
-			InputResource:    resource,
-			OutputResource:   &core.Resource{Url: &outUrl},
-			MetadataResource: defaultMetadataResource(),
-			Hooks:            "mov",
-			ExecutionStrategy: &core.ExecutionStrategy{
-				Executor: "docker",
-				Config: map[string]string{
-					"image": "jnkroeker/mov_converter:0.1.4",
-				},
-			},
-			Timeout: mie4nitfTimeout,
-		}
-	},
+func Mov(config *Config) *tasker.Template {
+	strategy := core.NewExecutionStrategy(core.ExecutorDocker, &core.ExecutorConfig{
+		Image:   config.Image,
+		Network: "bridge",
+	})
+	return &tasker.Template{
+		Name:     "Mov",
+		Output:   config.mirrorOutput(),
+		Strategy: strategy,
+		Create: func(resource *core.Resource) *core.Task {
+			if strings.ToLower(path.Ext(resource.Url.Path)) != ".mov" {
+				return nil
+			}
+
+			return &core.Task{
+				InputResource:     resource,
+				MetadataResource:  config.metadataResource(),
+				Hooks:             core.HooksMOV,
+				ExecutionStrategy: strategy,
+				Timeout:           config.Timeout,
+			}
+		},
+	}
 }
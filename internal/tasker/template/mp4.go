@@ -1,7 +1,6 @@
 package template
 
 import (
-	"os"
 	"path"
 	"strings"
 	"time"
@@ -10,37 +9,32 @@ import (
 	"kroekerlabs.dev/chyme/services/internal/tasker"
 )
 
-func init() {
-	if timeout := os.Getenv("CH_TEMPLATE_MP4_TIMEOUT"); timeout != "" {
-
-	}
+var Mp4Defaults = Config{
+	Timeout: time.Duration(48) * time.Hour,
+	Image:   "jnkroeker/mp4_processor:0.1.4",
 }
 
-var mp4Timeout = time.Duration(48) * time.Hour
-
-var Mp4 = &tasker.Template{
-	Name: "Mp4",
-	Create: func(resource *core.Resource) *core.Task {
-		if strings.ToLower(path.Ext(resource.Url.Path)) != ".mp4" {
-			return nil
-		}
-
-		outUrl := *resource.Url
-		outUrl.Path = path.Join(os.Getenv("CH_TEMPLATE_MP4_MIRROR_PREFIX"), outUrl.Host, outUrl.Path) + "/"
-		outUrl.Host = os.Getenv("CH_TEMPLATE_MP4_MIRROR_BUCKET")
-
-		return &core.Task{
-			InputResource:    resource,
-			OutputResource:   &core.Resource{Url: &outUrl},
-			MetadataResource: defaultMetadataResource(),
-			Hooks:            "mp4",
-			ExecutionStrategy: &core.ExecutionStrategy{
-				Executor: "docker",
-				Config: map[string]string{
-					"image": "jnkroeker/mp4_processor:0.1.4",
-				},
-			},
-			Timeout: mie4nitfTimeout,
-		}
-	},
+func Mp4(config *Config) *tasker.Template {
+	strategy := core.NewExecutionStrategy(core.ExecutorDocker, &core.ExecutorConfig{
+		Image:   config.Image,
+		Network: "bridge",
+	})
+	return &tasker.Template{
+		Name:     "Mp4",
+		Output:   config.mirrorOutput(),
+		Strategy: strategy,
+		Create: func(resource *core.Resource) *core.Task {
+			if strings.ToLower(path.Ext(resource.Url.Path)) != ".mp4" {
+				return nil
+			}
+
+			return &core.Task{
+				InputResource:     resource,
+				MetadataResource:  config.metadataResource(),
+				Hooks:             core.HooksMP4,
+				ExecutionStrategy: strategy,
+				Timeout:           config.Timeout,
+			}
+		},
+	}
 }
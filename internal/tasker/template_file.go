@@ -0,0 +1,341 @@
+package tasker
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+	"kroekerlabs.dev/chyme/services/internal/core"
+)
+
+// Timeout applied to Tasks created from a TemplateSpec that does not specify one.
+const DefaultTemplateTimeout = time.Duration(48) * time.Hour
+
+// TemplateSpec is the declarative form of a Template. Specs are read from YAML or JSON files so that new processing
+// images can be added without recompiling Chyme.
+type TemplateSpec struct {
+	Name     string            `yaml:"name"`
+	Version  string            `yaml:"version"`
+	Match    MatchSpec         `yaml:"match"`
+	Output   string            `yaml:"output"`
+	Metadata string            `yaml:"metadata"`
+	Hooks    string            `yaml:"hooks"`
+	Executor ExecutorSpec      `yaml:"executor"`
+	Timeout  string            `yaml:"timeout"`
+	Env      map[string]string `yaml:"env"`
+	Next     []string          `yaml:"next"`
+	FollowUp bool              `yaml:"followUp"`
+	Chunks   *ChunksSpec       `yaml:"chunks"`
+	Join     string            `yaml:"join"`
+}
+
+// ChunksSpec is the declarative form of a ChunkSpec, e.g. {mode: time, count: 4, segment: 15m}.
+type ChunksSpec struct {
+	Mode    string `yaml:"mode"`
+	Count   int    `yaml:"count"`
+	Segment string `yaml:"segment"`
+}
+
+// MatchSpec selects the Resources a TemplateSpec applies to. Every non-empty rule must match; within a rule any entry
+// may match. A follow-up TemplateSpec without rules applies to every output it is given.
+type MatchSpec struct {
+	Extensions []string `yaml:"extensions"`
+	Prefixes   []string `yaml:"prefixes"`
+	Regex      string   `yaml:"regex"`
+}
+
+type ExecutorSpec struct {
+	Name string `yaml:"name"`
+	// Version of the config schema, the current one unless set. An explicit version 0 config is the string map of
+	// earlier releases, which is converted when read.
+	Version int                 `yaml:"version"`
+	Config  core.ExecutorConfig `yaml:"config"`
+}
+
+func (s *ExecutorSpec) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var version struct {
+		Name    string      `yaml:"name"`
+		Version *int        `yaml:"version"`
+		Config  interface{} `yaml:"config"`
+	}
+	if err := unmarshal(&version); err != nil {
+		return err
+	}
+	if version.Version == nil || *version.Version != 0 {
+		type plain ExecutorSpec
+		return unmarshal((*plain)(s))
+	}
+
+	var legacy struct {
+		Name    string            `yaml:"name"`
+		Version int               `yaml:"version"`
+		Config  map[string]string `yaml:"config"`
+	}
+	if err := unmarshal(&legacy); err != nil {
+		return fmt.Errorf("invalid version 0 executor config: %s", err.Error())
+	}
+	config, err := core.LegacyExecutorConfig(legacy.Config)
+	if err != nil {
+		return fmt.Errorf("invalid version 0 executor config: %s", err.Error())
+	}
+	s.Name, s.Version, s.Config = legacy.Name, core.ExecutorConfigVersion, *config
+	return nil
+}
+
+// Compiles the spec into a Template. Output is an OutputTemplate pattern.
+func (s *TemplateSpec) Template() (*Template, error) {
+	if s.Name == "" {
+		return nil, errors.New("template has no name")
+	}
+
+	match, err := s.Match.compile(s.FollowUp)
+	if err != nil {
+		return nil, fmt.Errorf("template %s: %s", s.Name, err.Error())
+	}
+
+	output, err := NewOutputTemplate(s.Output)
+	if err != nil {
+		return nil, fmt.Errorf("template %s: %s", s.Name, err.Error())
+	}
+
+	var metadataURL *url.URL
+	if s.Metadata != "" {
+		if metadataURL, err = parseResourceURL(s.Metadata); err != nil {
+			return nil, fmt.Errorf("template %s: invalid metadata: %s", s.Name, err.Error())
+		}
+	}
+
+	if !core.KnownHooks(s.Hooks) {
+		return nil, fmt.Errorf("template %s: unknown hooks %s: must be %s, %s or none", s.Name, s.Hooks,
+			core.HooksMOV, core.HooksMP4)
+	}
+
+	timeout := DefaultTemplateTimeout
+	if s.Timeout != "" {
+		if timeout, err = time.ParseDuration(s.Timeout); err != nil {
+			return nil, fmt.Errorf("template %s: invalid timeout: %s", s.Name, err.Error())
+		}
+		if timeout <= 0 {
+			return nil, fmt.Errorf("template %s: timeout must be positive", s.Name)
+		}
+	}
+
+	var chunks *ChunkSpec
+	if s.Chunks != nil {
+		chunks = &ChunkSpec{Mode: core.ChunkMode(s.Chunks.Mode), Count: s.Chunks.Count}
+		if s.Chunks.Segment != "" {
+			if chunks.Segment, err = time.ParseDuration(s.Chunks.Segment); err != nil {
+				return nil, fmt.Errorf("template %s: invalid chunk segment: %s", s.Name, err.Error())
+			}
+		}
+	}
+
+	if s.Executor.Version != 0 && s.Executor.Version != core.ExecutorConfigVersion {
+		return nil, fmt.Errorf("template %s: unsupported executor config version %d", s.Name, s.Executor.Version)
+	}
+	config := s.Executor.Config
+	if len(s.Env) > 0 {
+		config.Env = make(map[string]string, len(s.Executor.Config.Env)+len(s.Env))
+		for k, v := range s.Executor.Config.Env {
+			config.Env[k] = v
+		}
+		for k, v := range s.Env {
+			config.Env[k] = v
+		}
+	}
+	strategy := core.NewExecutionStrategy(s.Executor.Name, &config)
+
+	template := &Template{
+		Name:     s.Name,
+		Output:   output,
+		Next:     s.Next,
+		FollowUp: s.FollowUp,
+		Chunks:   chunks,
+		Join:     s.Join,
+		Strategy: strategy,
+		Version:  s.Version,
+		Create: func(resource *core.Resource) *core.Task {
+			if !match(resource) {
+				return nil
+			}
+
+			var metadataResource *core.Resource
+			if metadataURL != nil {
+				u := *metadataURL
+				metadataResource = &core.Resource{Url: &u}
+			}
+
+			return &core.Task{
+				InputResource:     resource,
+				MetadataResource:  metadataResource,
+				Hooks:             s.Hooks,
+				ExecutionStrategy: strategy,
+				Timeout:           timeout,
+			}
+		},
+	}
+	if err := template.Validate(); err != nil {
+		return nil, err
+	}
+	return template, nil
+}
+
+func (m *MatchSpec) compile(optional bool) (func(resource *core.Resource) bool, error) {
+	var re *regexp.Regexp
+	if m.Regex != "" {
+		var err error
+		if re, err = regexp.Compile(m.Regex); err != nil {
+			return nil, fmt.Errorf("invalid match regex: %s", err.Error())
+		}
+	}
+	if len(m.Extensions) == 0 && len(m.Prefixes) == 0 && re == nil && !optional {
+		return nil, errors.New("no match rules specified")
+	}
+
+	exts := make([]string, len(m.Extensions))
+	for i, ext := range m.Extensions {
+		exts[i] = "." + strings.TrimPrefix(strings.ToLower(ext), ".")
+	}
+
+	return func(resource *core.Resource) bool {
+		if len(exts) > 0 && !containsString(exts, strings.ToLower(path.Ext(resource.Url.Path))) {
+			return false
+		}
+		if len(m.Prefixes) > 0 && !hasAnyPrefix(strings.TrimPrefix(resource.Url.Path, "/"), m.Prefixes) {
+			return false
+		}
+		if re != nil && !re.MatchString(resource.String()) {
+			return false
+		}
+		return true
+	}, nil
+}
+
+// File-backed Templater. Templates are compiled from every .yaml, .yml and .json file in a directory.
+type fileTemplater struct {
+	sync.RWMutex
+	dir       string
+	version   string
+	templater Templater
+}
+
+// Creates a Templater from the TemplateSpecs in dir. Reload re-reads the directory; if any spec fails to load the
+// previously loaded Templates are kept.
+func NewFileTemplater(dir string, version string) (Templater, error) {
+	t := &fileTemplater{dir: dir, version: version}
+	if err := t.Reload(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *fileTemplater) Create(resource *core.Resource) ([]*core.Task, error) {
+	t.RLock()
+	defer t.RUnlock()
+	return t.templater.Create(resource)
+}
+
+func (t *fileTemplater) Reload() error {
+	specs, err := LoadTemplateSpecs(t.dir)
+	if err != nil {
+		return err
+	}
+
+	templates := make([]*Template, 0, len(specs))
+	names := make(map[string]bool)
+	for _, spec := range specs {
+		if names[spec.Name] {
+			return fmt.Errorf("duplicate template name %s", spec.Name)
+		}
+		names[spec.Name] = true
+
+		template, err := spec.Template()
+		if err != nil {
+			return err
+		}
+		templates = append(templates, template)
+	}
+	if err := ValidateTemplates(templates); err != nil {
+		return err
+	}
+
+	t.Lock()
+	defer t.Unlock()
+	t.templater = NewInMemTemplater(templates, t.version)
+	return nil
+}
+
+// Reads every TemplateSpec file in dir, ordered by filename.
+func LoadTemplateSpecs(dir string) ([]*TemplateSpec, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	filenames := make([]string, 0)
+	for _, f := range files {
+		switch strings.ToLower(filepath.Ext(f.Name())) {
+		case ".yaml", ".yml", ".json":
+			if !f.IsDir() {
+				filenames = append(filenames, f.Name())
+			}
+		}
+	}
+	sort.Strings(filenames)
+
+	specs := make([]*TemplateSpec, 0, len(filenames))
+	for _, name := range filenames {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		// JSON is valid YAML, so a single decoder handles both formats.
+		spec := &TemplateSpec{}
+		if err := yaml.UnmarshalStrict(b, spec); err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err.Error())
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+func parseResourceURL(s string) (*url.URL, error) {
+	if s == "" {
+		return nil, errors.New("empty url")
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("%s must be of the form <scheme>://<host>/<path>", s)
+	}
+	return u, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, el := range list {
+		if el == s {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, pfx := range prefixes {
+		if strings.HasPrefix(s, strings.TrimPrefix(pfx, "/")) {
+			return true
+		}
+	}
+	return false
+}
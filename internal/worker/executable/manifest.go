@@ -0,0 +1,75 @@
+// package executable provides Go-native Task implementations for the inmem executor.
+package executable
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+
+	"kroekerlabs.dev/chyme/services/internal/core"
+)
+
+// Name of the file Manifest writes to the output directory.
+const ManifestFilename = "manifest.json"
+
+// Writes a manifest of the files of a Task's input: their path relative to the input directory, size and SHA-256.
+type Manifest struct{}
+
+type ManifestEntry struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+func (Manifest) Execute(ctx context.Context, task *core.Task, workspace *core.TaskWorkspace) error {
+	entries := make([]*ManifestEntry, 0)
+	err := filepath.Walk(workspace.InputDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(workspace.InputDir, path)
+		if err != nil {
+			return err
+		}
+		sum, err := fileSHA256(path)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, &ManifestEntry{Path: filepath.ToSlash(rel), Size: info.Size(), SHA256: sum})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(filepath.Join(workspace.OutputDir, ManifestFilename))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(entries)
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
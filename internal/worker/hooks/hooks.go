@@ -4,6 +4,7 @@ package hooks
 import (
 	"context"
 
+	"github.com/go-kit/kit/log"
 	"kroekerlabs.dev/chyme/services/internal/core"
 )
 
@@ -32,4 +33,12 @@ func (Base) PreUpload(ctx context.Context, task *core.Task) error {
 
 func (Base) PostUpload(ctx context.Context, task *core.Task) error {
 	return nil
-}
\ No newline at end of file
+}
+
+// Returns a logger for a hook's log statements, which discards them if the hook has no logger.
+func hookLogger(logger log.Logger, hook string, point string, task *core.Task) log.Logger {
+	if logger == nil {
+		return log.NewNopLogger()
+	}
+	return log.With(logger, "hook", hook, "point", point, "task", task.Hash())
+}
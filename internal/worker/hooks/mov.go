@@ -2,8 +2,9 @@ package hooks
 
 import (
 	"context"
-	"fmt"
 
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
 	"kroekerlabs.dev/chyme/services/internal/core"
 )
 
@@ -11,24 +12,25 @@ type MOV struct {
 	Base
 	TaskLoader     core.TaskLoader
 	ResourceLoader core.ResourceLoader
+	Logger         log.Logger
 }
 
 func (m *MOV) PreDownload(ctx context.Context, task *core.Task) error {
-	fmt.Println("MOV predownload hook")
+	level.Debug(hookLogger(m.Logger, "mov", "pre_download", task)).Log("msg", "running hook")
 	return nil
 }
 
 func (m *MOV) PreExecute(ctx context.Context, task *core.Task) error {
-	fmt.Println("MOV preexecute hook")
+	level.Debug(hookLogger(m.Logger, "mov", "pre_execute", task)).Log("msg", "running hook")
 	return nil
 }
 
 func (m *MOV) PreUpload(ctx context.Context, task *core.Task) error {
-	fmt.Println("MOV preupload hook")
+	level.Debug(hookLogger(m.Logger, "mov", "pre_upload", task)).Log("msg", "running hook")
 	return nil
 }
 
 func (m *MOV) PostUpload(ctx context.Context, task *core.Task) error {
-	fmt.Println("MOV postupload hook")
+	level.Debug(hookLogger(m.Logger, "mov", "post_upload", task)).Log("msg", "running hook")
 	return nil
 }
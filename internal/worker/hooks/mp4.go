@@ -2,8 +2,9 @@ package hooks
 
 import (
 	"context"
-	"fmt"
 
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
 	"kroekerlabs.dev/chyme/services/internal/core"
 )
 
@@ -11,24 +12,25 @@ type MP4 struct {
 	Base
 	TaskLoader     core.TaskLoader
 	ResourceLoader core.ResourceLoader
+	Logger         log.Logger
 }
 
 func (m *MP4) PreDownload(ctx context.Context, task *core.Task) error {
-	fmt.Println("MP4 predownload hook")
+	level.Debug(hookLogger(m.Logger, "mp4", "pre_download", task)).Log("msg", "running hook")
 	return nil
 }
 
 func (m *MP4) PreExecute(ctx context.Context, task *core.Task) error {
-	fmt.Println("MP4 preexecute hook")
+	level.Debug(hookLogger(m.Logger, "mp4", "pre_execute", task)).Log("msg", "running hook")
 	return nil
 }
 
 func (m *MP4) PreUpload(ctx context.Context, task *core.Task) error {
-	fmt.Println("MP4 preupload hook")
+	level.Debug(hookLogger(m.Logger, "mp4", "pre_upload", task)).Log("msg", "running hook")
 	return nil
 }
 
 func (m *MP4) PostUpload(ctx context.Context, task *core.Task) error {
-	fmt.Println("MP4 postupload hook")
+	level.Debug(hookLogger(m.Logger, "mp4", "post_upload", task)).Log("msg", "running hook")
 	return nil
 }
@@ -0,0 +1,32 @@
+package worker
+
+import (
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
+)
+
+// Metrics of the worker service.
+type Metrics struct {
+	// Tasks being processed by the worker.
+	TasksInProcess metrics.Gauge
+	// Tasks the worker finished processing, with template and outcome (complete, failed, interrupted or
+	// retried, when the Task succeeded but its follow-ups could not be enqueued) labels.
+	TasksProcessed metrics.Counter
+	// Seconds each ProcessStage of a Task took, with a stage label.
+	StageDuration metrics.Histogram
+	// Exits of the executions of Tasks, with executor and code labels.
+	Exits metrics.Counter
+	// Tasks moved to the dead letter queue, with a template label.
+	DeadLetters metrics.Counter
+}
+
+// Metrics that are not recorded anywhere.
+func NopMetrics() *Metrics {
+	return &Metrics{
+		TasksInProcess: discard.NewGauge(),
+		TasksProcessed: discard.NewCounter(),
+		StageDuration:  discard.NewHistogram(),
+		Exits:          discard.NewCounter(),
+		DeadLetters:    discard.NewCounter(),
+	}
+}
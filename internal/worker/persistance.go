@@ -6,12 +6,15 @@ import (
 	"os"
 	"path/filepath"
 
-	"kroekerlabs.dev/chyme/services/internal/core")
+	"kroekerlabs.dev/chyme/services/internal/core"
+	"kroekerlabs.dev/chyme/services/pkg/tracing")
 
 type State struct {
 	Stage       ProcessStage
 	TaskMessage *core.TaskMessage
 	Version     string
+	// Trace context of the Task, which is not part of its message body.
+	TraceContext tracing.Carrier
 }
 
 func (s *State) Encode(w io.Writer) error {
@@ -38,7 +41,7 @@ func NewFSPersister(workDir string) Persister {
 }
 
 func (p *fsPersister) Persist(state *State) error {
-	f, err := os.OpenFile(filepath.Join(state.TaskMessage.Task.Workspace.InternalDir, StateFilename), os.O_CREATE|os.O_WRONLY, 0600)
+	f, err := os.OpenFile(filepath.Join(state.TaskMessage.Task.Workspace.InternalDir, StateFilename), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
 	if err != nil {
 		return err
 	}
@@ -48,6 +51,10 @@ func (p *fsPersister) Persist(state *State) error {
 
 func (p *fsPersister) Load() (states []*State, err error) {
 	states = make([]*State, 0)
+	// Nothing was persisted if the worker never created a workspace.
+	if _, err := os.Stat(p.workDir); os.IsNotExist(err) {
+		return states, nil
+	}
 	err = filepath.Walk(p.workDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil || info.IsDir() || info.Name() != StateFilename {
 			return err
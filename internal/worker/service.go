@@ -3,40 +3,94 @@ package worker
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"kroekerlabs.dev/chyme/services/internal/core"
 	"kroekerlabs.dev/chyme/services/internal/worker/hooks"
+	"kroekerlabs.dev/chyme/services/pkg/aws"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
 	"github.com/hashicorp/go-multierror"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"kroekerlabs.dev/chyme/services/pkg/tracing"
 )
 
 type Service interface {
 	Poll(ctx context.Context, processErrCh chan error) error 
+	Resume(ctx context.Context, processErrCh chan error) error
 	Process(ctx context.Context, task *core.Task, taskHooks hooks.Interface, stage ProcessStage) (ProcessStage, error) 
 	InProcess() []*core.Task
+	// Reports the Tasks in process, with the IDs of their executions where the executor can list them.
+	Status(ctx context.Context) ([]*TaskStatus, error)
+	// Waits for the Tasks in process to finish, or to be persisted once the context of Poll and Resume is canceled.
+	// It returns an error if ctx is done first.
+	Wait(ctx context.Context) error
 }
 
 type Config struct {
-	TaskQueue    core.TaskQueue 
-	TaskLoader   core.TaskLoader
-	TaskExecutor core.TaskExecutor 
-	Hooks        hooks.Registry
-	Persister    Persister
-	Version      string 
+	TaskQueue      core.TaskQueue 
+	TaskRepository core.TaskRepository
+	TaskLoader     core.TaskLoader
+	TaskExecutor   core.TaskExecutor 
+	Hooks          hooks.Registry
+	Persister      Persister
+	Version        string 
+	// Logger of the service, which discards the log if nil.
+	Logger         log.Logger
+	// Metrics of the service, which are discarded if nil.
+	Metrics        *Metrics
+	// Time executions found on startup that match no persisted State are kept for their Task's message, left in
+	// flight by a worker that exited before persisting, to be received again; DefaultOrphanTimeout unless set.
+	OrphanTimeout  time.Duration
 	// Concurrency  int
 }
 
+// Longest a message stays in flight, after which any worker may receive it again.
+const DefaultOrphanTimeout = aws.MaxVisbilityTimeoutSeconds * time.Second
+
 type service struct {
 	*Config 
 	sync.Mutex 
 	inProcess              map[string]*core.Task 
+	// Messages of the Tasks in process by Task hash.
+	messages               map[string]*core.TaskMessage
+	progress               map[string]*taskProgress
 	inProcNotificationChan chan int 
+	// Goroutines processing Tasks, which must finish before the worker exits.
+	processing             sync.WaitGroup
+	// Executions by Task hash that matched no persisted State on startup. A dequeued message of their Task claims
+	// them; those still unclaimed at claimDeadline are reaped.
+	unclaimed              map[string]*core.Execution
+	claimDeadline          time.Time
+}
+
+// Stage a Task in process is at, and when the worker began processing it.
+type taskProgress struct {
+	stage ProcessStage
+	began time.Time
 }
 
 func New(config *Config) Service {
-	return &service{Config: config, inProcess: make(map[string]*core.Task)}
+	if config.Logger == nil {
+		config.Logger = log.NewNopLogger()
+	}
+	if config.Metrics == nil {
+		config.Metrics = NopMetrics()
+	}
+	if config.OrphanTimeout == 0 {
+		config.OrphanTimeout = DefaultOrphanTimeout
+	}
+	return &service{
+		Config:    config,
+		inProcess: make(map[string]*core.Task),
+		messages:  make(map[string]*core.TaskMessage),
+		progress:  make(map[string]*taskProgress),
+		unclaimed: make(map[string]*core.Execution),
+	}
 }
 
 // Polls the task queue and processes received Tasks.
@@ -46,30 +100,175 @@ func (s *service) Poll(ctx context.Context, processErrCh chan error) error {
 	// 	nInProc = <-s.requestInprocNotification()
 	// }
 
+	if err := s.reapUnclaimed(ctx); err != nil {
+		level.Error(s.Logger).Log("msg", "failed to reap unclaimed executions", "err", err)
+	}
+
+	dequeued := time.Now()
 	messages, err := s.TaskQueue.Dequeue(1)
 	if err != nil {
 		return err 
 	}
 
 	for _, message := range messages {
-		fmt.Println("message pulled from task queue")
-		s.setInProcess(message.Task)
-		go func(msg *core.TaskMessage) {
-			if err := s.processMessage(ctx, msg, Start); err != nil {
+		level.Debug(s.taskLogger(message.Task)).Log("msg", "task dequeued")
+		_, span := tracing.Tracer().Start(tracing.Extract(ctx, message.Task.TraceContext), "worker.dequeue",
+			trace.WithTimestamp(dequeued), trace.WithAttributes(taskAttributes(message.Task)...))
+		span.End()
+		if s.adopt(message) {
+			continue
+		}
+		stage := s.claim(message.Task)
+		s.setInProcess(message, stage)
+		s.processing.Add(1)
+		go func(msg *core.TaskMessage, stage ProcessStage) {
+			defer s.processing.Done()
+			if err := s.processMessage(ctx, msg, stage); err != nil {
 				processErrCh <- err 
 			}
 			s.clearInProcess(msg.Task)
-		}(message)
+		}(message, stage)
+	}
+
+	return nil
+}
+
+// Resumes the Tasks persisted by a previous run of the worker from the stage they were interrupted at. Executions left
+// on the host that belong to none of the Tasks in process are kept for their messages to claim.
+func (s *service) Resume(ctx context.Context, processErrCh chan error) error {
+	states, err := s.Persister.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load persisted tasks: %s", err.Error())
+	}
+
+	for _, state := range states {
+		level.Info(s.taskLogger(state.TaskMessage.Task)).Log("msg", "resuming task", "stage", state.Stage)
+		state.TaskMessage.Task.TraceContext = state.TraceContext
+		s.setInProcess(state.TaskMessage, state.Stage)
+		s.processing.Add(1)
+		go func(state *State) {
+			defer s.processing.Done()
+			if err := s.processMessage(ctx, state.TaskMessage, state.Stage); err != nil {
+				processErrCh <- err
+			}
+			s.clearInProcess(state.TaskMessage.Task)
+		}(state)
+	}
+
+	return s.reconcileExecutions(ctx)
+}
+
+// Matches the executions on the host to the Tasks in process. Those of no Task in process, e.g. containers of a worker
+// that crashed before it could persist its state, are left for a message of their Task to claim: the crashed worker's
+// message becomes visible again once its visibility timeout passes, and is received by this or another worker.
+func (s *service) reconcileExecutions(ctx context.Context) error {
+	lister, ok := s.TaskExecutor.(core.ExecutionLister)
+	if !ok {
+		return nil
+	}
+	executions, err := lister.Executions(ctx)
+
+	s.Lock()
+	defer s.Unlock()
+	keep := make(map[string]bool, len(s.inProcess)+len(executions))
+	for hash := range s.inProcess {
+		keep[hash] = true
+	}
+	for _, execution := range executions {
+		keep[execution.TaskHash] = true
+		if _, ok := s.inProcess[execution.TaskHash]; ok {
+			continue
+		}
+		level.Info(s.Logger).Log("msg", "keeping execution for its task's message", "execution", execution.ID,
+			"task", execution.TaskHash, "until", time.Now().Add(s.OrphanTimeout).Format(time.RFC3339))
+		s.unclaimed[execution.TaskHash] = execution
+	}
+	s.claimDeadline = time.Now().Add(s.OrphanTimeout)
+	if err != nil {
+		return err
 	}
 
+	// Secret files of Tasks with neither an execution nor a message in process were left by a worker that stopped
+	// without removing them. They are swept only if every execution was listed, so that none still in use is removed.
+	if sweeper, ok := s.TaskExecutor.(core.SecretSweeper); ok {
+		if err := sweeper.SweepSecrets(keep); err != nil {
+			level.Warn(s.Logger).Log("msg", "failed to sweep secret files", "err", err)
+		}
+	}
 	return nil
 }
 
-func (s *service) setInProcess(task *core.Task) {
+// Claims the execution left on the host for the Task, if any. A claimed Task is processed from the Execute stage,
+// which reattaches to the execution, as its input was downloaded before the execution was created.
+func (s *service) claim(task *core.Task) ProcessStage {
+	s.Lock()
+	execution, ok := s.unclaimed[task.Hash()]
+	delete(s.unclaimed, task.Hash())
+	s.Unlock()
+	if !ok {
+		return Start
+	}
+
+	logger := s.taskLogger(task)
+	if err := s.TaskLoader.CreateWorkspace(task); err != nil {
+		level.Warn(logger).Log("msg", "failed to open the workspace of a claimed execution, starting over",
+			"execution", execution.ID, "err", err)
+		return Start
+	}
+	level.Info(logger).Log("msg", "claimed execution", "execution", execution.ID)
+	return Execute
+}
+
+// Removes the executions that no message claimed by the claim deadline. Without the Task's message they could never
+// be reported as complete.
+func (s *service) reapUnclaimed(ctx context.Context) error {
+	s.Lock()
+	if len(s.unclaimed) == 0 || time.Now().Before(s.claimDeadline) {
+		s.Unlock()
+		return nil
+	}
+	unclaimed := s.unclaimed
+	s.unclaimed = make(map[string]*core.Execution)
+	s.Unlock()
+
+	lister := s.TaskExecutor.(core.ExecutionLister)
+	errs := &multierror.Error{}
+	for _, execution := range unclaimed {
+		level.Info(s.Logger).Log("msg", "reaping unclaimed execution", "execution", execution.ID, "task", execution.TaskHash)
+		errs = multierror.Append(errs, lister.Reap(ctx, execution))
+	}
+	return errs.ErrorOrNil()
+}
+
+// Hands a message of a Task already in process, e.g. one resumed from its persisted State whose message became
+// visible again, to the processing of that Task instead of processing it twice. SQS only deletes a message by the
+// receipt handle of its latest delivery, so the Task's message takes this one's. Reports whether the Task was in
+// process; the message is then left in flight.
+func (s *service) adopt(message *core.TaskMessage) bool {
+	s.Lock()
+	running, ok := s.messages[message.Task.Hash()]
+	s.Unlock()
+	if !ok {
+		return false
+	}
+
+	level.Info(s.taskLogger(message.Task)).Log("msg", "task redelivered while in process, adopting its message")
+	running.Task.Lock()
+	defer running.Task.Unlock()
+	running.MessageHandle = message.MessageHandle
+	running.Timeout = message.Timeout
+	return true
+}
+
+func (s *service) setInProcess(message *core.TaskMessage, stage ProcessStage) {
 	s.Lock()
 	defer s.Unlock()
 
+	task := message.Task
 	s.inProcess[task.Hash()] = task 
+	s.messages[task.Hash()] = message
+	s.progress[task.Hash()] = &taskProgress{stage: stage, began: time.Now()}
+	s.Metrics.TasksInProcess.Set(float64(len(s.inProcess)))
 	s.inProcNotify(len(s.inProcess))
 }
 
@@ -78,6 +277,9 @@ func (s *service) clearInProcess(task *core.Task) {
 	defer s.Unlock()
 
 	delete(s.inProcess, task.Hash())
+	delete(s.messages, task.Hash())
+	delete(s.progress, task.Hash())
+	s.Metrics.TasksInProcess.Set(float64(len(s.inProcess)))
 	s.inProcNotify(len(s.inProcess))
 }
 
@@ -85,22 +287,48 @@ func (s *service) processMessage(ctx context.Context, message *core.TaskMessage,
 	// Resolve the hooks specified for the Task
 	taskHooks, ok := s.Hooks[message.Task.Hooks]
 	if !ok {
-		return s.TaskQueue.Fail(message, fmt.Errorf("unknown task hooks %s", message.Task.Hooks))
+		return s.fail(message, fmt.Errorf("unknown task hooks %s", message.Task.Hooks))
 	}
 
 	// Delete this message from the queue if we exceed its timeout while processing.
-	// If timeout already exceeded, then a different machine will pick it up
+	// If timeout already exceeded, then a different machine will pick it up. This happens to Tasks resumed after
+	// their message became visible again, so free what this worker holds for them.
 	untilTimeout := time.Until(message.Timeout)
 	if untilTimeout < time.Second*10 {
-		return nil 
+		return multierror.Append(s.TaskLoader.Clean(message.Task), s.TaskExecutor.Clean(message.Task)).ErrorOrNil()
 	}
 	timeout := time.AfterFunc(untilTimeout, func() { s.TaskQueue.Delete(message) })
 
+	// A Task that completed before its follow-ups failed to enqueue is not processed again; only the enqueue is retried.
+	if completed, err := s.TaskRepository.Completed(message.Task); err != nil {
+		level.Warn(s.taskLogger(message.Task)).Log("msg", "failed to read completion of task", "err", err)
+	} else if completed {
+		level.Info(s.taskLogger(message.Task)).Log("msg", "task already complete, enqueuing its follow-ups")
+		stage = Complete
+	}
+
+	// The Task's spans continue the trace of the span that enqueued it.
+	ctx, span := tracing.Tracer().Start(tracing.Extract(ctx, message.Task.TraceContext), "worker.process",
+		trace.WithAttributes(append(taskAttributes(message.Task), attribute.String("worker.stage", string(stage)))...))
+	var taskErr error
+	defer func() { tracing.End(span, taskErr) }()
+
 	// Process the Task and persist state if Process errors due to the provided context being canceled
+	logger := s.taskLogger(message.Task)
+	began := time.Now()
+	level.Info(logger).Log("msg", "processing task", "stage", stage)
 	currentStage, err := s.Process(ctx, message.Task, taskHooks, stage)
 	timeout.Stop()
 	if isCtxCanceled(err) {
-		return s.Persister.Persist(&State{currentStage, message, s.Version})
+		level.Info(logger).Log("msg", "task interrupted", "stage", currentStage, "duration", time.Since(began))
+		s.Metrics.TasksProcessed.With("template", message.Task.Template, "outcome", "interrupted").Add(1)
+		taskErr = err
+		return s.Persister.Persist(&State{
+			Stage:        currentStage,
+			TaskMessage:  message,
+			Version:      s.Version,
+			TraceContext: message.Task.TraceContext,
+		})
 	}
 	errs := &multierror.Error{}
 	errs = multierror.Append(errs, err)
@@ -110,10 +338,145 @@ func (s *service) processMessage(ctx context.Context, message *core.TaskMessage,
 	errs = multierror.Append(errs, s.TaskExecutor.Clean(message.Task))
 
 	if errs.ErrorOrNil() != nil {
-		return s.TaskQueue.Fail(message, errs)
+		level.Error(logger).Log("msg", "task failed", "stage", currentStage, "duration", time.Since(began), "err", errs)
+		taskErr = errs
+		return s.fail(message, errs)
+	}
+
+	// The Task itself succeeded, so its message is not failed if its follow-ups cannot be enqueued. It is left for
+	// redelivery instead, which only retries them.
+	if stage != Complete && (len(message.Task.Next) > 0 || message.Task.Join != nil) {
+		if err := s.TaskRepository.SetCompleted(message.Task); err != nil {
+			level.Warn(logger).Log("msg", "failed to record completion of task", "err", err)
+		}
+	}
+	if err := s.enqueueNext(ctx, message.Task); err != nil {
+		level.Error(logger).Log("msg", "failed to enqueue follow-up tasks, leaving the task for redelivery", "err", err)
+		s.Metrics.TasksProcessed.With("template", message.Task.Template, "outcome", "retried").Add(1)
+		taskErr = err
+		return fmt.Errorf("failed to enqueue follow-up tasks of %s: %s", message.Task.Hash(), err.Error())
 	}
 
-	return s.TaskQueue.Delete(message)
+	level.Info(logger).Log("msg", "task complete", "duration", time.Since(began))
+	s.Metrics.TasksProcessed.With("template", message.Task.Template, "outcome", "complete").Add(1)
+	if err := s.TaskQueue.Delete(message); err != nil {
+		return err
+	}
+	if len(message.Task.Next) > 0 || message.Task.Join != nil {
+		if err := s.TaskRepository.ClearCompleted(message.Task); err != nil {
+			level.Warn(logger).Log("msg", "failed to clear completion of task", "err", err)
+		}
+	}
+	return nil
+}
+
+// Moves the Task of a message to the dead letter queue.
+func (s *service) fail(message *core.TaskMessage, err error) error {
+	s.Metrics.TasksProcessed.With("template", message.Task.Template, "outcome", "failed").Add(1)
+	s.Metrics.DeadLetters.With("template", message.Task.Template).Add(1)
+	return s.TaskQueue.Fail(message, err)
+}
+
+// Returns the service's logger with the fields of the Task.
+func (s *service) taskLogger(task *core.Task) log.Logger {
+	return log.With(s.Logger, "task", task.Hash(), "template", task.Template)
+}
+
+// Returns the span attributes of the Task.
+func taskAttributes(task *core.Task) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		attribute.String("task.hash", task.Hash()),
+		attribute.String("task.template", task.Template),
+	}
+	if task.InputResource != nil {
+		attrs = append(attrs, attribute.String("task.input", task.InputResource.String()))
+	}
+	return attrs
+}
+
+// Enqueues the follow-up Tasks of a completed Task. The join Task of a fan-out is enqueued by whichever chunk
+// completes last.
+func (s *service) enqueueNext(ctx context.Context, task *core.Task) error {
+	for _, next := range task.Next {
+		if err := s.enqueueFollowUp(ctx, next, task.ChainID()); err != nil {
+			return err
+		}
+	}
+
+	if task.Join == nil {
+		return nil
+	}
+	var complete bool
+	err := s.retry(ctx, task, "completing chunk", func() (err error) {
+		complete, err = s.TaskRepository.CompleteChunk(task)
+		return
+	})
+	if err != nil || !complete {
+		return err
+	}
+	if err := s.enqueueFollowUp(ctx, task.Join, task.ChainID()); err != nil {
+		if releaseErr := s.TaskRepository.ReleaseJoin(task); releaseErr != nil {
+			level.Error(s.taskLogger(task)).Log("msg", "failed to release the join of the chunk group", "err", releaseErr)
+		}
+		return err
+	}
+	// The join Task is enqueued, so failing to record that only risks its claim expiring while a duplicate of a chunk
+	// is processed.
+	if err := s.retry(ctx, task, "recording the join", func() error { return s.TaskRepository.JoinEnqueued(task) }); err != nil {
+		level.Warn(s.taskLogger(task)).Log("msg", "failed to record the join of the chunk group as enqueued", "err", err)
+	}
+	return nil
+}
+
+// Attempts of each step of enqueuing follow-up Tasks, and the delay before the first retry, which doubles with each.
+const (
+	followUpAttempts   = 5
+	followUpRetryDelay = time.Second
+)
+
+// Calls f until it succeeds, it was attempted followUpAttempts times or ctx is done. Returns the last error of f.
+func (s *service) retry(ctx context.Context, task *core.Task, step string, f func() error) error {
+	delay := followUpRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || attempt == followUpAttempts {
+			return err
+		}
+		level.Warn(s.taskLogger(task)).Log("msg", "retrying follow-up step", "step", step, "attempt", attempt,
+			"err", err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+		delay *= 2
+	}
+}
+
+// Enqueues a follow-up Task, recording it under the ID of the chain it belongs to.
+func (s *service) enqueueFollowUp(ctx context.Context, task *core.Task, chainID string) (err error) {
+	task.ParentID = chainID
+	ctx, span := tracing.Tracer().Start(ctx, "worker.enqueue", trace.WithAttributes(taskAttributes(task)...))
+	defer func() { tracing.End(span, err) }()
+	task.TraceContext = tracing.Inject(ctx)
+
+	// A follow-up enqueued before an earlier attempt failed is recorded already, and is not enqueued again. Each step
+	// is retried on its own, so that a retry does not enqueue the Task twice.
+	var exists bool
+	err = s.retry(ctx, task, "checking", func() (err error) {
+		exists, err = s.TaskRepository.Has(task)
+		return
+	})
+	if err != nil || exists {
+		return err
+	}
+	if err := s.retry(ctx, task, "enqueuing", func() error { return s.TaskQueue.Enqueue(task) }); err != nil {
+		return err
+	}
+	if err := s.retry(ctx, task, "recording", func() error { return s.TaskRepository.Add(task) }); err != nil {
+		return err
+	}
+	return s.retry(ctx, task, "recording in chain", func() error { return s.TaskRepository.AddToChain(task) })
 }
 
 type ProcessStage string
@@ -128,53 +491,102 @@ const (
 )
 
 // Downloads, executes and uploads a single Task.
-func (s *service) Process(ctx context.Context, task *core.Task, taskHooks hooks.Interface, stage ProcessStage) (ProcessStage, error) {
+func (s *service) Process(ctx context.Context, task *core.Task, taskHooks hooks.Interface, stage ProcessStage) (current ProcessStage, err error) {
 	result := &core.ExecutionResult{}
 	execErr := &multierror.Error{}
+	logger := s.taskLogger(task)
+	began := time.Now()
+	// Logs the duration of the stage that has just completed and starts timing the next one.
+	stageDone := func(completed ProcessStage) {
+		elapsed := time.Since(began)
+		level.Debug(logger).Log("msg", "stage complete", "stage", completed, "duration", elapsed)
+		s.Metrics.StageDuration.With("stage", string(completed)).Observe(elapsed.Seconds())
+		began = time.Now()
+	}
+	// Each stage has a span, which ends when the next stage starts or Process returns.
+	var span trace.Span
+	startStage := func(stage ProcessStage) context.Context {
+		if span != nil {
+			span.End()
+		}
+		var stageCtx context.Context
+		stageCtx, span = tracing.Tracer().Start(ctx, "worker."+string(stage))
+		s.setStage(task, stage)
+		return stageCtx
+	}
+	defer func() {
+		if span != nil {
+			tracing.End(span, err)
+		}
+	}()
 
 	switch stage {
 	case Start:
+		startStage(Start)
 		if err := s.TaskLoader.CreateWorkspace(task); err != nil {
 			return Start, fmt.Errorf("failed to create workspace: %s", err.Error())
 		}
+		stageDone(Start)
 		fallthrough
 	case Download: 
-		if err := taskHooks.PreDownload(ctx, task); err != nil {
+		ctx := startStage(Download)
+		if err := runHook(ctx, "pre_download", taskHooks.PreDownload, task); err != nil {
 			return Download, fmt.Errorf("during pre-download hook: %s", err.Error())
 		}
 		if err := s.TaskLoader.Download(ctx, task); err != nil {
 			return Download, fmt.Errorf("during download: %s", err.Error())
 		}
+		stageDone(Download)
 		fallthrough
 	case Execute:
-		if err := taskHooks.PreExecute(ctx, task); err != nil {
+		ctx := startStage(Execute)
+		if err := runHook(ctx, "pre_execute", taskHooks.PreExecute, task); err != nil {
 			return Execute, fmt.Errorf("during pre-execute hook: %s", err.Error())
 		}
 		res, err := s.TaskExecutor.Execute(ctx, task)
 		if err != nil {
-			fmt.Println("Fatal: " + err.Error())
+			level.Error(logger).Log("msg", "executor failed", "stage", Execute, "err", err)
 		}
 		if isCtxCanceled(err) {
 			return Execute, err 
 		}
-		result = res
-		execErr = multierror.Append(execErr, err)        // Error from Tsunami infrastructure
-		execErr = multierror.Append(execErr, result.Err) // Error from the client code being executed (e.g. container)
-		execErr = multierror.Append(execErr, s.TaskLoader.UploadMetadata(task, result))
+		// The digest is only kept on the Task, whose message is deleted once it completes.
+		if task.ImageDigest != "" {
+			if err := s.TaskRepository.RecordImage(task); err != nil {
+				level.Error(logger).Log("msg", "failed to record image digest", "digest", task.ImageDigest, "err", err)
+			}
+		}
+		execErr = multierror.Append(execErr, err) // Error from Tsunami infrastructure
+		if res != nil {
+			s.recordExit(task, res)
+			result = res
+			execErr = multierror.Append(execErr, result.Err) // Error from the client code being executed (e.g. container)
+			execErr = multierror.Append(execErr, s.TaskLoader.UploadMetadata(task, result))
+		}
 		if err := execErr.ErrorOrNil(); err != nil {
 			return Metadata, fmt.Errorf("error(s) during execution: %s", err.Error())
 		}
+		stageDone(Execute)
 		fallthrough
 	case Upload:
-		if err := taskHooks.PreUpload(ctx, task); err != nil {
+		ctx := startStage(Upload)
+		if err := runHook(ctx, "pre_upload", taskHooks.PreUpload, task); err != nil {
 			return Upload, fmt.Errorf("during pre-upload hook: %s", err.Error())
 		}
-		if err := s.TaskLoader.Upload(ctx, task, result.OutputPath); err != nil {
+		// A Task resumed at this stage has no execution result; its output is in the workspace.
+		outputPath := result.OutputPath
+		if outputPath == "" {
+			outputPath = task.Workspace.OutputDir
+		}
+		if err := s.TaskLoader.Upload(ctx, task, outputPath); err != nil {
 			return Upload, fmt.Errorf("failed to upload task output: %s", err.Error())
 		}
-		if err := taskHooks.PostUpload(ctx, task); err != nil {
+		if err := runHook(ctx, "post_upload", taskHooks.PostUpload, task); err != nil {
 			return Upload, fmt.Errorf("during post-upload hook: %s", err.Error())
 		}
+		stageDone(Upload)
+	case Complete:
+		// Completed by an earlier delivery of the Task's message.
 	default:
 		return Start, fmt.Errorf("invalid process stage %s", stage)
 	}
@@ -182,6 +594,27 @@ func (s *service) Process(ctx context.Context, task *core.Task, taskHooks hooks.
 	return Complete, nil
 }
 
+// Runs a hook of a Task in a span of its own.
+func runHook(ctx context.Context, point string, hook func(context.Context, *core.Task) error, task *core.Task) error {
+	ctx, span := tracing.Tracer().Start(ctx, "hook."+point)
+	err := hook(ctx, task)
+	tracing.End(span, err)
+	return err
+}
+
+// Records the exit code of a Task's execution. Errors other than the exit of the execution have no code.
+func (s *service) recordExit(task *core.Task, result *core.ExecutionResult) {
+	code := 0
+	if result.Err != nil {
+		exitErr, ok := result.Err.(*core.ExitError)
+		if !ok {
+			return
+		}
+		code = exitErr.Code
+	}
+	s.Metrics.Exits.With("executor", task.ExecutionStrategy.Executor, "code", strconv.Itoa(code)).Add(1)
+}
+
 // TODO: Make this return something that is not a pointer to this service's internal state.
 func (s *service) InProcess() []*core.Task {
 	s.Lock()
@@ -189,6 +622,31 @@ func (s *service) InProcess() []*core.Task {
 	return taskMapToSlice(s.inProcess)
 }
 
+func (s *service) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.processing.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%d tasks still in process: %s", len(s.InProcess()), ctx.Err().Error())
+	}
+}
+
+// Records the stage a Task in process has reached.
+func (s *service) setStage(task *core.Task, stage ProcessStage) {
+	s.Lock()
+	defer s.Unlock()
+
+	if progress, ok := s.progress[task.Hash()]; ok {
+		progress.stage = stage
+	}
+}
+
 // inProcNotify is called from a worker goroutine to notify the main goroutine when the number of in process tasks
 // changes.
 func (s *service) inProcNotify(length int) {
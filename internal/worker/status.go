@@ -0,0 +1,58 @@
+package worker
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"kroekerlabs.dev/chyme/services/internal/core"
+)
+
+// Status of a Task in process.
+type TaskStatus struct {
+	Hash     string       `json:"hash"`
+	Template string       `json:"template"`
+	Input    string       `json:"input,omitempty"`
+	Stage    ProcessStage `json:"stage"`
+	Began    time.Time    `json:"began"`
+	// Seconds since the worker began processing the Task.
+	Elapsed float64 `json:"elapsed"`
+	// ID of the Task's container, if it has one the executor can list.
+	ContainerID string `json:"containerId,omitempty"`
+}
+
+// Reports the Tasks in process, oldest first. Failing to list the executions of the Tasks is returned with the
+// statuses, which then lack the IDs of their containers.
+func (s *service) Status(ctx context.Context) ([]*TaskStatus, error) {
+	now := time.Now()
+	s.Lock()
+	statuses := make([]*TaskStatus, 0, len(s.inProcess))
+	for hash, task := range s.inProcess {
+		status := &TaskStatus{Hash: hash, Template: task.Template}
+		if task.InputResource != nil {
+			status.Input = task.InputResource.String()
+		}
+		if progress, ok := s.progress[hash]; ok {
+			status.Stage = progress.stage
+			status.Began = progress.began
+			status.Elapsed = now.Sub(progress.began).Seconds()
+		}
+		statuses = append(statuses, status)
+	}
+	s.Unlock()
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Began.Before(statuses[j].Began) })
+
+	lister, ok := s.TaskExecutor.(core.ExecutionLister)
+	if !ok || len(statuses) == 0 {
+		return statuses, nil
+	}
+	executions, err := lister.Executions(ctx)
+	containerIDs := make(map[string]string, len(executions))
+	for _, execution := range executions {
+		containerIDs[execution.TaskHash] = execution.ID
+	}
+	for _, status := range statuses {
+		status.ContainerID = containerIDs[status.Hash]
+	}
+	return statuses, err
+}
@@ -0,0 +1,100 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Checks that a dependency of the worker, e.g. the Docker daemon, is reachable.
+type Check func(ctx context.Context) error
+
+// Time each readiness check and the listing of executions for /status are given.
+const probeTimeout = 5 * time.Second
+
+type ReadyResponse struct {
+	Ready bool `json:"ready"`
+	// Result of each check by name: "ok" or the error.
+	Checks map[string]string `json:"checks"`
+}
+
+type StatusResponse struct {
+	Tasks []*TaskStatus `json:"tasks"`
+	Err   string        `json:"err,omitempty"`
+}
+
+// Registers the worker's probes with mux. /healthz answers 200 while the worker is running, /readyz answers 200 if
+// every check passes and 503 otherwise, and /status lists the Tasks in process.
+func HandleHTTP(mux *http.ServeMux, svc Service, checks map[string]Check) {
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		response := runChecks(r.Context(), checks)
+		status := http.StatusOK
+		if !response.Ready {
+			status = http.StatusServiceUnavailable
+		}
+		encodeJSON(w, status, response)
+	})
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), probeTimeout)
+		defer cancel()
+		tasks, err := svc.Status(ctx)
+		response := StatusResponse{Tasks: tasks}
+		if err != nil {
+			response.Err = err.Error()
+		}
+		encodeJSON(w, http.StatusOK, response)
+	})
+}
+
+// Runs the checks concurrently, so that the probe takes as long as the slowest check rather than all of them. A check
+// that has not answered within probeTimeout fails, even if it ignores its context.
+func runChecks(ctx context.Context, checks map[string]Check) ReadyResponse {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	type result struct {
+		name string
+		err  error
+	}
+	results := make(chan result, len(checks))
+	for name, check := range checks {
+		go func(name string, check Check) {
+			results <- result{name, check(ctx)}
+		}(name, check)
+	}
+
+	response := ReadyResponse{Ready: true, Checks: make(map[string]string, len(checks))}
+	record := func(name string, err error) {
+		if err != nil {
+			response.Ready = false
+			response.Checks[name] = err.Error()
+			return
+		}
+		response.Checks[name] = "ok"
+	}
+	for pending := len(checks); pending > 0; pending-- {
+		select {
+		case r := <-results:
+			record(r.name, r.err)
+		case <-ctx.Done():
+			for name := range checks {
+				if _, ok := response.Checks[name]; !ok {
+					record(name, fmt.Errorf("no answer within %s", probeTimeout))
+				}
+			}
+			return response
+		}
+	}
+	return response
+}
+
+func encodeJSON(w http.ResponseWriter, status int, response interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
@@ -17,6 +17,8 @@ import (
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
 	"golang.org/x/sync/errgroup"
 	"kroekerlabs.dev/chyme/services/pkg/util"
 )
@@ -28,6 +30,7 @@ const maxDeleteObjectsKeys = 1000
 type Bucket interface {
 	ListObjects(options *ListObjectsOptions, visit func(object *s3.Object) error) error
 	Download(ctx context.Context, key string, w io.WriterAt) (int64, error)
+	DownloadRange(ctx context.Context, key string, w io.WriterAt, first int64, last int64) (int64, error)
 	DownloadPrefix(ctx context.Context, key string, dir string, depth int) (int64, error)
 	Upload(ctx context.Context, key string, r io.Reader, metadata map[string]*string) (int64, error)
 	UploadDirectory(ctx context.Context, dir string, basePrefix string) (int64, error)
@@ -44,13 +47,18 @@ type s3Bucket struct {
 	downloader      *s3manager.Downloader
 	uploader        *s3manager.Uploader
 	defaultMetadata map[string]*string
+	logger          log.Logger
 }
 
-func NewS3Bucket(svc *s3.S3, name string) Bucket {
+// Creates a Bucket for the named S3 bucket. The logger may be nil.
+func NewS3Bucket(svc *s3.S3, name string, logger log.Logger) Bucket {
 	downloader := s3manager.NewDownloaderWithClient(svc)
 	uploader := s3manager.NewUploaderWithClient(svc)
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
 
-	return &s3Bucket{name, svc, downloader, uploader, nil}
+	return &s3Bucket{name, svc, downloader, uploader, nil, log.With(logger, "bucket", name)}
 }
 
 /** list objects of s3 bucket specifics **/
@@ -62,13 +70,10 @@ type ListObjectsOptions struct {
 
 func (b *s3Bucket) ListObjects(options *ListObjectsOptions, visit func(object *s3.Object) error) error {
 	depth := options.Depth
-	fmt.Println("here in ListObjects")
-	fmt.Println(options.RootPrefix)
-	fmt.Println(depth)
-	fmt.Println(b.name)
+	level.Debug(b.logger).Log("msg", "listing objects", "prefix", options.RootPrefix, "depth", depth)
 
 	//user specified recusion depth is the maximum depth; we start from 1 when calling lister.list
-	lister := lister{b.svc, visit, depth}
+	lister := lister{b.svc, visit, depth, b.logger}
 	return lister.list(&s3.ListObjectsV2Input{
 		Bucket: aws.String(b.name),
 		// Prefix as below is exactly the same as above Bucket param
@@ -88,9 +93,19 @@ func (b *s3Bucket) Download(ctx context.Context, key string, w io.WriterAt) (int
 	return int64(cw.BytesWritten), err
 }
 
+// Downloads bytes first through last (inclusive) of an object, writing them from the start of w.
+func (b *s3Bucket) DownloadRange(ctx context.Context, key string, w io.WriterAt, first int64, last int64) (int64, error) {
+	cw := &util.CountingWriterAt{WriterAt: w}
+	_, err := b.downloader.DownloadWithContext(ctx, cw, &s3.GetObjectInput{
+		Bucket: aws.String(b.name),
+		Key:    aws.String(key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", first, last)),
+	})
+	return int64(cw.BytesWritten), err
+}
+
 func (b *s3Bucket) Upload(ctx context.Context, key string, r io.Reader, metadata map[string]*string) (int64, error) {
-	fmt.Println("In s3 bucket Upload")
-	fmt.Println(key)
+	level.Debug(b.logger).Log("msg", "uploading object", "key", key)
 	cr := &util.CountingReader{Reader: r}
 	// mergeMetadata(metadata, b.defaultMetadata)
 	_, err := b.uploader.UploadWithContext(ctx, &s3manager.UploadInput{
@@ -104,9 +119,7 @@ func (b *s3Bucket) Upload(ctx context.Context, key string, r io.Reader, metadata
 
 // Uploads a directory by appending the paths of its subtree (relative to the base directory)
 func (b *s3Bucket) UploadDirectory(ctx context.Context, dir string, basePrefix string) (int64, error) {
-	fmt.Println("In s3 directory Upload")
-	fmt.Println(dir)
-	fmt.Println(basePrefix)
+	level.Debug(b.logger).Log("msg", "uploading directory", "dir", dir, "key", basePrefix)
 	iter, readers, err := b.directoryToUploadIterator(dir, basePrefix)
 	if err != nil {
 		return 0, err
@@ -161,10 +174,13 @@ func PathToKey(baseDir string, basePrefix string, path string) string {
 	return strings.Join([]string{basePrefix, rel}, "/")
 }
 
+// Downloads the objects under a prefix, up to depth levels deep, into dir. Objects below the first level are written
+// to the same relative path under dir.
 func (b *s3Bucket) DownloadPrefix(ctx context.Context, key string, dir string, depth int) (int64, error) {
-	if depth != 1 {
-		return 0, errors.New("download recursion depth > 1 not implemented")
+	if depth < 1 {
+		return 0, errors.New("download recursion depth must be at least 1")
 	}
+	root := strings.TrimLeft(key, "/")
 
 	objects := make([]*s3.Object, 0)
 	mtx := sync.Mutex{}
@@ -182,7 +198,16 @@ func (b *s3Bucket) DownloadPrefix(ctx context.Context, key string, dir string, d
 	writers := util.CountingWriterAts(make([]*util.CountingWriterAt, len(objects)))
 
 	for i, obj := range objects {
-		f, err := os.OpenFile(filepath.Join(dir, path.Base(*obj.Key)), os.O_CREATE|os.O_WRONLY, 0600)
+		rel := strings.TrimPrefix(*obj.Key, root)
+		if rel == "" || strings.HasSuffix(rel, "/") {
+			rel = path.Base(*obj.Key)
+		}
+		filePath := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(filePath), 0700); err != nil {
+			return 0, err
+		}
+
+		f, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY, 0600)
 		if err != nil {
 			return 0, err
 		}
@@ -312,6 +337,7 @@ type lister struct {
 	svc      *s3.S3
 	visit    func(*s3.Object) error
 	maxDepth int
+	logger   log.Logger
 }
 
 func (l *lister) list(input *s3.ListObjectsV2Input, depth int) error {
@@ -323,6 +349,8 @@ func (l *lister) list(input *s3.ListObjectsV2Input, depth int) error {
 	if err != nil {
 		return err
 	}
+	level.Debug(l.logger).Log("msg", "listed prefix", "prefix", aws.StringValue(input.Prefix),
+		"objects", len(objects), "prefixes", len(prefixes))
 	var g errgroup.Group
 	for _, object := range objects {
 		obj := object
@@ -342,10 +370,8 @@ func list(svc *s3.S3, input *s3.ListObjectsV2Input) ([]*s3.Object, []*string, er
 	prefixes := make([]*string, 0)
 
 	res, err := svc.ListObjectsV2(input)
-	fmt.Println("list objects response")
-	fmt.Println(res.Contents)
 	if err != nil {
-		return nil, nil, fmt.Errorf("error listing prefix %s: %s", *input.Prefix, err.Error())
+		return nil, nil, fmt.Errorf("error listing prefix %s: %s", aws.StringValue(input.Prefix), err.Error())
 	}
 	objects = append(objects, res.Contents...)
 	for _, commonPrefix := range res.CommonPrefixes {
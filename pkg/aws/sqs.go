@@ -1,6 +1,7 @@
 package aws
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -110,7 +111,12 @@ func (q *SqsQueue) Delete(h Handled) error {
 const SQSMessageCountAttribute = "ApproximateNumberOfMessages"
 
 func (q *SqsQueue) MessageCount() (int, error) {
-	res, err := q.svc.GetQueueAttributes(&sqs.GetQueueAttributesInput{
+	return q.MessageCountWithContext(context.Background())
+}
+
+// Like MessageCount, but the request is abandoned once ctx is done.
+func (q *SqsQueue) MessageCountWithContext(ctx context.Context) (int, error) {
+	res, err := q.svc.GetQueueAttributesWithContext(ctx, &sqs.GetQueueAttributesInput{
 		QueueUrl:       &q.url,
 		AttributeNames: []*string{aws.String(SQSMessageCountAttribute)},
 	})
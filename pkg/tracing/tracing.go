@@ -0,0 +1,95 @@
+// package tracing carries OpenTelemetry trace context between the Chyme services, which hand work to each other
+// through Redis and SQS rather than by calls.
+package tracing
+
+import (
+	"context"
+	"net/url"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Name of the tracer the services create spans with.
+const InstrumentationName = "kroekerlabs.dev/chyme/services"
+
+// Trace context in the form it is propagated in, e.g. {"traceparent": "00-<trace id>-<span id>-01"}. It is a
+// propagation.TextMapCarrier.
+type Carrier map[string]string
+
+func (c Carrier) Get(key string) string {
+	return c[key]
+}
+
+func (c Carrier) Set(key string, value string) {
+	c[key] = value
+}
+
+func (c Carrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// Returns the tracer of the globally registered provider, which records nothing unless one was registered.
+func Tracer() trace.Tracer {
+	return otel.Tracer(InstrumentationName)
+}
+
+// Returns the trace context of ctx, or nil if it has none.
+func Inject(ctx context.Context) Carrier {
+	carrier := Carrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return nil
+	}
+	return carrier
+}
+
+// Returns a copy of ctx with the trace context of the carrier, so that spans started from it continue the trace.
+func Extract(ctx context.Context, carrier Carrier) context.Context {
+	if len(carrier) == 0 {
+		return ctx
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, carrier)
+}
+
+// Names of the keys a carrier can have.
+func Fields() []string {
+	return otel.GetTextMapPropagator().Fields()
+}
+
+// Encodes the carrier as a string, for stores that hold strings only.
+func (c Carrier) Encode() string {
+	values := url.Values{}
+	for k, v := range c {
+		values.Set(k, v)
+	}
+	return values.Encode()
+}
+
+// Decodes a carrier encoded by Encode. A malformed string yields an empty carrier, as trace context is never worth
+// failing over.
+func Decode(s string) Carrier {
+	values, err := url.ParseQuery(s)
+	if err != nil {
+		return nil
+	}
+	carrier := make(Carrier, len(values))
+	for k := range values {
+		carrier[k] = values.Get(k)
+	}
+	return carrier
+}
+
+// Ends the span, recording err on it if it is not nil.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
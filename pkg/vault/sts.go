@@ -0,0 +1,104 @@
+package vault
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// Name of the STSProvider in AWS credential values.
+const STSProviderName = "VaultSTSProvider"
+
+// TTL requested for STS credentials unless configured otherwise.
+const DefaultSTSTTL = 30 * time.Minute
+
+// Interval a failed issue is retried at while the credentials issued before are still valid.
+const stsRetryInterval = 30 * time.Second
+
+// AWS credentials.Provider that issues STS credentials from a Vault AWS secrets engine role, e.g. aws/sts/chyme.
+//
+// STS leases cannot be renewed, so credentials are issued again once four fifths of their lease has passed. If Vault
+// cannot be reached then, the credentials issued before are used for as long as they are valid, and the issue is
+// retried every stsRetryInterval.
+type STSProvider struct {
+	credentials.Expiry
+
+	client *Client
+	path   string
+	ttl    time.Duration
+	logger log.Logger
+
+	last       credentials.Value
+	expiration time.Time
+}
+
+// Creates an STSProvider issuing credentials from the role at path. The logger may be nil.
+func NewSTSProvider(client *Client, path string, ttl time.Duration, logger log.Logger) *STSProvider {
+	if ttl == 0 {
+		ttl = DefaultSTSTTL
+	}
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	return &STSProvider{client: client, path: path, ttl: ttl, logger: logger}
+}
+
+// Creates AWS credentials backed by an STSProvider.
+func NewSTSCredentials(client *Client, path string, ttl time.Duration, logger log.Logger) *credentials.Credentials {
+	return credentials.NewCredentials(NewSTSProvider(client, path, ttl, logger))
+}
+
+// Issues new credentials. It is called by credentials.Credentials when the credentials it holds have expired, which
+// serializes the calls.
+func (p *STSProvider) Retrieve() (credentials.Value, error) {
+	value, lease, err := p.issue()
+	if err != nil {
+		if time.Until(p.expiration) > stsRetryInterval {
+			level.Warn(p.logger).Log("msg", "failed to issue AWS credentials, using the current ones",
+				"path", p.path, "expiration", p.expiration.Format(time.RFC3339), "err", err)
+			p.SetExpiration(time.Now().Add(stsRetryInterval), 0)
+			return p.last, nil
+		}
+		return credentials.Value{ProviderName: STSProviderName}, fmt.Errorf("failed to issue AWS credentials: %s", err.Error())
+	}
+
+	p.last = value
+	p.expiration = time.Now().Add(lease)
+	p.SetExpiration(p.expiration, lease/5)
+	level.Debug(p.logger).Log("msg", "issued AWS credentials", "path", p.path, "lease", lease)
+	return value, nil
+}
+
+func (p *STSProvider) issue() (credentials.Value, time.Duration, error) {
+	secret, err := p.client.Logical().Write(p.path, map[string]interface{}{
+		"ttl": p.ttl.String(),
+	})
+	if err != nil {
+		return credentials.Value{}, 0, err
+	}
+	if secret == nil || secret.Data == nil {
+		return credentials.Value{}, 0, fmt.Errorf("%s: %w", p.path, ErrSecretNotFound)
+	}
+
+	key, _ := secret.Data["access_key"].(string)
+	secretKey, _ := secret.Data["secret_key"].(string)
+	token, _ := secret.Data["security_token"].(string)
+	if key == "" || secretKey == "" {
+		return credentials.Value{}, 0, errors.New(p.path + ": no access_key or secret_key issued")
+	}
+
+	lease := time.Duration(secret.LeaseDuration) * time.Second
+	if lease == 0 {
+		lease = p.ttl
+	}
+	return credentials.Value{
+		AccessKeyID:     key,
+		SecretAccessKey: secretKey,
+		SessionToken:    token,
+		ProviderName:    STSProviderName,
+	}, lease, nil
+}
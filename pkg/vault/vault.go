@@ -0,0 +1,57 @@
+package vault
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// Returned when no secret exists at a path.
+var ErrSecretNotFound = errors.New("secret not found")
+
+// Vault client authenticated with a token.
+type Client struct {
+	*api.Client
+}
+
+func NewClient(address string, token string) (*Client, error) {
+	client, err := api.NewClient(&api.Config{Address: address})
+	if err != nil {
+		return nil, err
+	}
+	client.SetToken(token)
+	return &Client{client}, nil
+}
+
+// Reads the data of the secret at path. The data of KV version 2 secrets is unwrapped, so both versions of the KV
+// secrets engine read the same.
+func (c *Client) ReadSecret(path string) (map[string]interface{}, error) {
+	secret, err := c.Logical().Read(path)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, fmt.Errorf("%s: %w", path, ErrSecretNotFound)
+	}
+
+	if data, ok := secret.Data["data"].(map[string]interface{}); ok {
+		if _, versioned := secret.Data["metadata"]; versioned {
+			return data, nil
+		}
+	}
+	return secret.Data, nil
+}
+
+// Reads a field of the secret at path as a string.
+func (c *Client) ReadSecretField(path string, field string) (string, error) {
+	data, err := c.ReadSecret(path)
+	if err != nil {
+		return "", err
+	}
+	value, ok := data[field].(string)
+	if !ok {
+		return "", fmt.Errorf("%s: no string field %s", path, field)
+	}
+	return value, nil
+}